@@ -0,0 +1,162 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/tlahdekorpi/archivegen/cpio"
+)
+
+const blockSize = 512
+
+var zeroBlock [blockSize]byte
+
+func clean(name string) string {
+	return strings.TrimLeft(path.Clean(name), "/")
+}
+
+// tarEnd returns the offset of the end-of-archive marker, which
+// follows the data of the last member.
+func tarEnd(f *os.File, dirs map[string]struct{}) (int64, error) {
+	var end int64
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return end, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		size := hdr.Size
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			dirs[clean(hdr.Name)] = struct{}{}
+			fallthrough
+		case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			// header only types.
+			size = 0
+		}
+		if sparse(hdr) {
+			// the size includes the holes, the data is read to
+			// find its end.
+			if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+				return 0, err
+			}
+			size = 0
+		}
+
+		off, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+		end = (off + size + blockSize - 1) / blockSize * blockSize
+	}
+}
+
+func sparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// cpioEnd returns the offset of the trailer and the last inode used.
+func cpioEnd(f *os.File, dirs map[string]struct{}) (int64, int64, error) {
+	var inode int64
+
+	cr := cpio.NewReader(f)
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		if hdr.Inode > inode {
+			inode = hdr.Inode
+		}
+		if hdr.Type == cpio.TypeDir {
+			dirs[clean(hdr.Name)] = struct{}{}
+		}
+	}
+
+	return cr.Offset(), inode, nil
+}
+
+type appendWriter struct {
+	Writer
+	dirs map[string]struct{}
+}
+
+func (w *appendWriter) WriteEntry(hdr *Header, r io.Reader) error {
+	if _, ok := w.dirs[clean(hdr.Name)]; ok && hdr.Type == TypeDir {
+		return nil
+	}
+	return w.Writer.WriteEntry(hdr, r)
+}
+
+func (w *appendWriter) SetNames(n *Names) {
+	if x, ok := w.Writer.(Namer); ok {
+		x.SetNames(n)
+	}
+}
+
+// Append reopens an existing archive f, removing its trailer. Subsequent
+// entries are written to w which must write to f. Directories already
+// in the archive are not written again to retain their permissions.
+func Append(format string, f *os.File, w io.Writer, opts Options) (Writer, error) {
+	var (
+		r    = &appendWriter{dirs: make(map[string]struct{})}
+		off  int64
+		ino  int64
+		err  error
+		stat os.FileInfo
+	)
+
+	if stat, err = f.Stat(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := tarFormats[format]; ok {
+		if stat.Size() > 0 {
+			off, err = tarEnd(f, r.dirs)
+		}
+		if err == nil {
+			r.Writer, err = newTar(w, opts, format)
+		}
+	} else if format == "cpio" {
+		if stat.Size() > 0 {
+			off, ino, err = cpioEnd(f, r.dirs)
+		}
+		if err == nil {
+			r.Writer, err = newCpio(w, opts, off, ino)
+		}
+	} else {
+		return nil, fmt.Errorf("append: unsupported format: %s", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("append: %v", err)
+	}
+
+	if err := f.Truncate(off); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
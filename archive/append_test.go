@@ -0,0 +1,184 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/tlahdekorpi/archivegen/config"
+	"github.com/tlahdekorpi/archivegen/cpio"
+)
+
+type stored struct {
+	name string
+	data []byte
+}
+
+func readMembers(t *testing.T, format, file string) []stored {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var r []stored
+	if format == "cpio" {
+		cr := cpio.NewReader(f)
+		for {
+			hdr, err := cr.Next()
+			if err == io.EOF {
+				return r
+			}
+			if err != nil {
+				t.Fatal(format, err)
+			}
+			d, err := ioutil.ReadAll(cr)
+			if err != nil {
+				t.Fatal(format, err)
+			}
+			r = append(r, stored{hdr.Name, d})
+		}
+	}
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return r
+		}
+		if err != nil {
+			t.Fatal(format, err)
+		}
+		d, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(format, err)
+		}
+		r = append(r, stored{hdr.Name, d})
+	}
+}
+
+func TestAppend(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_append")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	sparse, sparseData := sparseFile(t, tmp)
+
+	// data ending in zero blocks.
+	zeros := make([]byte, 3*blockSize)
+	zeros[0] = 'z'
+
+	for _, format := range []string{"tar", "tar-pax", "tar-gnu", "cpio"} {
+		file := path.Join(tmp, "out."+format)
+		for i, v := range [][]func(Writer) error{
+			{
+				func(w Writer) error { return writeDir(w, "d", 0755, 0, 0) },
+				func(w Writer) error { return createFile(w, config.Entry{Dst: "d/a", Mode: 0644, Data: []byte("a")}) },
+				func(w Writer) error { return createFile(w, config.Entry{Dst: "d/z", Mode: 0644, Data: zeros}) },
+			},
+			{
+				func(w Writer) error { return writeDir(w, "d", 0700, 0, 0) },
+				func(w Writer) error { return writeFile(w, config.Entry{Src: sparse, Dst: "d/s", Mode: 0644}) },
+			},
+			{
+				func(w Writer) error { return createFile(w, config.Entry{Dst: "b", Mode: 0644, Data: []byte("b")}) },
+			},
+		} {
+			f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := Append(format, f, f, nil)
+			if err != nil {
+				t.Fatal(format, i, err)
+			}
+			for _, fn := range append(v, func(w Writer) error { return w.Close() }) {
+				if err := fn(w); err != nil {
+					t.Fatal(format, i, err)
+				}
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		want := []stored{
+			{"d", nil},
+			{"d/a", []byte("a")},
+			{"d/z", zeros},
+			{"d/s", sparseData},
+			{"b", []byte("b")},
+		}
+		have := readMembers(t, format, file)
+		if len(have) != len(want) {
+			t.Fatalf("%s: %d members, want %d", format, len(have), len(want))
+		}
+		for k, v := range want {
+			h := have[k]
+			if clean(h.name) != v.name || !bytes.Equal(h.data, v.data) {
+				t.Errorf("%s: member %d: %s, %d bytes, want %s, %d bytes",
+					format, k, h.name, len(h.data), v.name, len(v.data),
+				)
+			}
+		}
+	}
+}
+
+// TestAppendTruncated appends to a tar archive ending in a single zero
+// block after data ending in zeros.
+func TestAppendTruncated(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_append")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	zeros := make([]byte, 2*blockSize)
+	zeros[0] = 'z'
+
+	b := new(bytes.Buffer)
+	tw := tar.NewWriter(b)
+	if err := tw.WriteHeader(&tar.Header{
+		Name: "z", Mode: 0644, Size: int64(len(zeros)), Typeflag: tar.TypeReg,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(zeros); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file := path.Join(tmp, "out.tar")
+	if err := ioutil.WriteFile(file, b.Bytes()[:b.Len()-blockSize], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := Append("tar", f, f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := createFile(w, config.Entry{Dst: "b", Mode: 0644, Data: []byte("b")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	have := readMembers(t, "tar", file)
+	if len(have) != 2 || !bytes.Equal(have[0].data, zeros) || have[1].name != "b" {
+		t.Errorf("members: %d", len(have))
+	}
+}
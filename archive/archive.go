@@ -1,12 +1,6 @@
 package archive
 
-import (
-	"archive/tar"
-	"io"
-	"os"
-
-	"github.com/tlahdekorpi/archivegen/cpio"
-)
+import "io"
 
 type FileType int
 
@@ -18,31 +12,42 @@ const (
 	TypeRegular
 	TypeSymlink
 	TypeSocket
+	TypeLink // hardlink to Linkname.
 )
 
 type Header struct {
-	Name string // name of header file entry.
-	Mode int64  // permission and mode bits.
-	Uid  int    // user id of owner.
-	Gid  int    // group id of owner.
-	Size int64  // length in bytes.
-	Type FileType
-	Time int64
+	Name     string            // name of header file entry.
+	Linkname string            // target name of link.
+	Mode     int64             // permission and mode bits.
+	Uid      int               // user id of owner.
+	Gid      int               // group id of owner.
+	Uname    string            // user name of owner.
+	Gname    string            // group name of owner.
+	Size     int64             // length in bytes.
+	Type     FileType          // type of entry.
+	Time     int64             // modified time; seconds since epoch.
+	Devmajor int64             // major number of character or block device.
+	Devminor int64             // minor number of character or block device.
+	Xattrs   map[string]string // extended attributes.
+	Sparse   []SparseEntry     // data regions of a sparse file.
+	Links    int               // number of hardlinks to a regular file.
 }
 
 type Writer interface {
-	io.WriteCloser
-	WriteHeader(hdr *Header) error
-	Symlink(src, dst string, uid, gid, mode int) error
-	WriteFile(file *os.File, hdr *Header) error
+	// WriteEntry writes hdr followed by hdr.Size bytes of content
+	// read from r, r is nil for entries without content. Links take
+	// their target from hdr.Linkname, Sparse requires r to be
+	// an *os.File.
+	WriteEntry(hdr *Header, r io.Reader) error
+	Close() error
 }
 
+// NewWriter returns a Writer for the named format with default
+// options, or nil if the format is not registered.
 func NewWriter(format string, w io.Writer) Writer {
-	switch format {
-	case "tar":
-		return &tarWriter{tar.NewWriter(w)}
-	case "cpio":
-		return &cpioWriter{cpio.NewWriter(w)}
+	r, err := New(format, w, nil)
+	if err != nil {
+		return nil
 	}
-	return nil
+	return r
 }
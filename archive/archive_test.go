@@ -0,0 +1,150 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/tlahdekorpi/archivegen/cpio"
+)
+
+var testEntries = []struct {
+	hdr  Header
+	data string
+}{
+	{Header{Name: "d", Mode: 0755, Type: TypeDir}, ""},
+	{Header{Name: "d/f", Mode: 0644, Size: 4, Type: TypeRegular, Time: 10,
+		Xattrs: map[string]string{"user.a": "b"}}, "data"},
+	{Header{Name: "d/l", Linkname: "f", Mode: 0777, Type: TypeSymlink, Time: 20}, ""},
+	{Header{Name: "d/c", Mode: 0600, Type: TypeChar, Devmajor: 1, Devminor: 3}, ""},
+}
+
+func writeEntries(t *testing.T, format string) *bytes.Buffer {
+	b := new(bytes.Buffer)
+	w := NewWriter(format, b)
+	for _, v := range testEntries {
+		hdr := v.hdr
+		var r io.Reader
+		if v.data != "" {
+			r = strings.NewReader(v.data)
+		}
+		if err := w.WriteEntry(&hdr, r); err != nil {
+			t.Fatal(format, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(format, err)
+	}
+	return b
+}
+
+func TestWriteEntryTar(t *testing.T) {
+	tr := tar.NewReader(writeEntries(t, "tar"))
+	for _, v := range testEntries {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSuffix(hdr.Name, "/") != v.hdr.Name ||
+			hdr.Linkname != v.hdr.Linkname ||
+			hdr.Devmajor != v.hdr.Devmajor ||
+			hdr.Devminor != v.hdr.Devminor ||
+			hdr.ModTime.Unix() != v.hdr.Time && v.hdr.Time > 0 {
+			t.Errorf("header %+v, want %+v", hdr, v.hdr)
+		}
+		for k, x := range v.hdr.Xattrs {
+			if y := hdr.PAXRecords[xattrPrefix+k]; y != x {
+				t.Errorf("%s: xattr %s %q, want %q", hdr.Name, k, y, x)
+			}
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != v.data {
+			t.Errorf("%s: data %q, want %q", hdr.Name, b, v.data)
+		}
+	}
+}
+
+func TestWriteEntryCpio(t *testing.T) {
+	cr := cpio.NewReader(writeEntries(t, "cpio"))
+	for _, v := range testEntries {
+		hdr, err := cr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSuffix(hdr.Name, "/") != v.hdr.Name ||
+			int64(hdr.Devmajor) != v.hdr.Devmajor ||
+			int64(hdr.Devminor) != v.hdr.Devminor ||
+			hdr.Mtime != v.hdr.Time {
+			t.Errorf("header %+v, want %+v", hdr, v.hdr)
+		}
+		b, err := ioutil.ReadAll(cr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := v.data
+		if v.hdr.Type == TypeSymlink {
+			want = v.hdr.Linkname
+		}
+		if string(b) != want {
+			t.Errorf("%s: data %q, want %q", hdr.Name, b, want)
+		}
+	}
+}
+
+func TestTarFormats(t *testing.T) {
+	var (
+		long  = strings.Repeat("a", 120)
+		xattr = map[string]string{"user.a": "b"}
+	)
+	for _, v := range []struct {
+		format string
+		hdr    Header
+		want   tar.Format // zero when the header is rejected.
+	}{
+		{"tar-ustar", Header{Name: "a"}, tar.FormatUSTAR},
+		{"tar-ustar", Header{Name: long}, 0},
+		{"tar-ustar", Header{Name: "a", Uid: 1 << 22}, 0},
+		{"tar-ustar", Header{Name: "a", Xattrs: xattr}, 0},
+		{"tar-pax", Header{Name: long}, tar.FormatPAX},
+		{"tar-pax", Header{Name: "a", Uid: 1 << 22}, tar.FormatPAX},
+		{"tar-pax", Header{Name: "a", Xattrs: xattr}, tar.FormatPAX},
+		{"tar-gnu", Header{Name: "a"}, tar.FormatGNU},
+		{"tar-gnu", Header{Name: long}, tar.FormatGNU},
+		{"tar-gnu", Header{Name: "a", Uid: 1 << 22}, tar.FormatGNU},
+		{"tar-gnu", Header{Name: "a", Xattrs: xattr}, 0},
+	} {
+		b := new(bytes.Buffer)
+		w := NewWriter(v.format, b)
+		hdr := v.hdr
+		hdr.Type = TypeRegular
+		err := w.WriteEntry(&hdr, nil)
+		if v.want == 0 {
+			if err == nil {
+				t.Errorf("%s: %.10s uid %d accepted", v.format, hdr.Name, hdr.Uid)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(v.format, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(v.format, err)
+		}
+
+		r, err := tar.NewReader(b).Next()
+		if err != nil {
+			t.Fatal(v.format, err)
+		}
+		if r.Format != v.want || r.Name != hdr.Name || r.Uid != hdr.Uid {
+			t.Errorf("%s: %.10s uid %d: format %v, want %v",
+				v.format, r.Name, r.Uid, r.Format, v.want,
+			)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/tlahdekorpi/archivegen/config"
+)
+
+type sum [sha256.Size]byte
+
+type member struct {
+	e   config.Entry
+	sum sum
+}
+
+// kind returns the archive type an entry is written as.
+func kind(t string) string {
+	switch t {
+	case config.TypeDirectory, config.TypeSymlink:
+		return t
+	}
+	return config.TypeRegular
+}
+
+func hashReader(r io.Reader) (s sum, err error) {
+	h := sha256.New()
+	if _, err = io.Copy(h, r); err != nil {
+		return
+	}
+	copy(s[:], h.Sum(nil))
+	return
+}
+
+// content returns the hash of the data an entry is written with.
+func content(e config.Entry, m *members) (sum, error) {
+	switch e.Type {
+	case config.TypeRegular:
+		f, err := os.Open(e.Src)
+		if err != nil {
+			return sum{}, err
+		}
+		defer f.Close()
+		return hashReader(f)
+	case config.TypeArchive:
+		r, _, err := m.open(e)
+		if err != nil {
+			return sum{}, err
+		}
+		return hashReader(r)
+	case config.TypeBase64:
+		return hashReader(base64.NewDecoder(
+			base64.StdEncoding, bytes.NewReader(e.Data),
+		))
+	}
+	return sha256.Sum256(e.Data), nil
+}
+
+// name returns the archive member name of an entry from walk.
+func name(e config.Entry) string {
+	if e.Type == config.TypeDirectory {
+		return e.Src
+	}
+	return e.Dst
+}
+
+// Check compares the archive read from r to the tree, returning
+// a description of every difference found.
+func (n *Node) Check(r io.Reader) ([]string, error) {
+	var (
+		order []string
+		want  = make(map[string]config.Entry)
+	)
+	if err := n.walk("", func(e config.Entry) error {
+		order = append(order, name(e))
+		want[name(e)] = e
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// later members override earlier ones when extracted.
+	have := make(map[string]member)
+	if err := config.ReadArchive(r, func(e config.Entry, r io.Reader) error {
+		m := member{e: e}
+		if e.Type == config.TypeRegular && e.Src != "" {
+			m.sum = have[e.Src].sum
+			m.e.Src = ""
+		} else if e.Type == config.TypeRegular {
+			var err error
+			if m.sum, err = hashReader(r); err != nil {
+				return err
+			}
+		}
+		have[e.Dst] = m
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// members of archives are read in the order they are written.
+	m := new(members)
+	defer m.close()
+
+	var ret []string
+	for _, k := range order {
+		h, ok := have[k]
+		if !ok {
+			ret = append(ret, fmt.Sprintf("%s: missing", k))
+			continue
+		}
+		d, err := diff(want[k], h, m)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, d...)
+	}
+
+	for k := range have {
+		if _, ok := want[k]; !ok {
+			ret = append(ret, fmt.Sprintf("%s: not in configuration", k))
+		}
+	}
+
+	sort.Strings(ret)
+	return ret, nil
+}
+
+func diff(w config.Entry, h member, m *members) ([]string, error) {
+	n := name(w)
+
+	if a, b := kind(h.e.Type), kind(w.Type); a != b {
+		return []string{fmt.Sprintf("%s: type %s, want %s", n, a, b)}, nil
+	}
+
+	var r []string
+	if h.e.Mode != w.Mode {
+		r = append(r, fmt.Sprintf("%s: mode %04o, want %04o", n, h.e.Mode, w.Mode))
+	}
+	if h.e.User != w.User || h.e.Group != w.Group {
+		r = append(r, fmt.Sprintf("%s: owner %d:%d, want %d:%d",
+			n, h.e.User, h.e.Group, w.User, w.Group,
+		))
+	}
+
+	switch kind(w.Type) {
+	case config.TypeSymlink:
+		if h.e.Src != w.Src {
+			r = append(r, fmt.Sprintf("%s: link %s, want %s", n, h.e.Src, w.Src))
+		}
+	case config.TypeRegular:
+		s, err := content(w, m)
+		if err != nil {
+			return nil, err
+		}
+		if s != h.sum {
+			r = append(r, fmt.Sprintf("%s: content %x, want %x", n, h.sum, s))
+		}
+	}
+
+	return r, nil
+}
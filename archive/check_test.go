@@ -0,0 +1,75 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/tlahdekorpi/archivegen/config"
+)
+
+func render(t *testing.T, s string) *Node {
+	c := new(config.Config)
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Render(m)
+}
+
+func TestCheck(t *testing.T) {
+	const want = `
+d a 0755 0 0
+c a/b 0644 1 2 data
+l b a/c
+`
+	for _, v := range []struct {
+		name string
+		have string
+		diff []string
+	}{
+		{"match", want, nil},
+		{"missing", `
+d a 0755 0 0
+c a/b 0644 1 2 data
+`, []string{"a/c: missing"}},
+		{"extra", want + "c a/d 0644 0 0 x\n", []string{"a/d: not in configuration"}},
+		{"mode", `
+d a 0700 0 0
+c a/b 0600 1 2 data
+l b a/c
+`, []string{"a/b: mode 0600, want 0644", "a: mode 0700, want 0755"}},
+		{"owner", `
+d a 0755 0 0
+c a/b 0644 2 1 data
+l b a/c
+`, []string{"a/b: owner 2:1, want 1:2"}},
+		{"content", `
+d a 0755 0 0
+c a/b 0644 1 2 other
+l c a/c
+`, []string{
+			fmt.Sprintf("a/b: content %x, want %x",
+				sha256.Sum256([]byte("other\n")), sha256.Sum256([]byte("data\n")),
+			),
+			"a/c: link c, want b",
+		}},
+		{"type", `
+d a 0755 0 0
+d a/b 0644 1 2
+l b a/c
+`, []string{"a/b: type d, want f"}},
+	} {
+		have := archiveOf(t, render(t, v.have))
+		r, err := render(t, want).Check(bytes.NewReader(have))
+		if err != nil {
+			t.Fatal(v.name, err)
+		}
+		if !reflect.DeepEqual(r, v.diff) {
+			t.Errorf("%s:\n%q\nwant:\n%q", v.name, r, v.diff)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package archive
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var errFallback = errors.New("copy: fallback")
+
+const maxCopy = 1 << 30
+
+// the copy methods, replaced in tests.
+var (
+	copyFileRange = func(dst, src int, n int) (int, error) {
+		return unix.CopyFileRange(src, nil, dst, nil, n, 0)
+	}
+	sendfile = func(dst, src int, n int) (int, error) {
+		return unix.Sendfile(dst, src, nil, n)
+	}
+)
+
+// unsupported reports if the error means the method can't be used
+// with these files.
+func unsupported(err error) bool {
+	switch err {
+	case
+		unix.ENOSYS,
+		unix.EXDEV,
+		unix.EINVAL,
+		unix.EOPNOTSUPP,
+		unix.EBADF,
+		unix.EPERM,
+		unix.EAGAIN:
+		return true
+	}
+	return false
+}
+
+// copyFile copies n bytes, or until the end of file if n is negative,
+// from the offset of src to dst with copy_file_range or sendfile.
+// errFallback is returned when the remaining data needs to be copied
+// some other way.
+func copyFile(dst, src *os.File, n int64) (int64, error) {
+	var (
+		w  int64
+		cr = true
+		fn = copyFileRange
+		d  = int(dst.Fd())
+		s  = int(src.Fd())
+	)
+
+	for n < 0 || w < n {
+		c := int64(maxCopy)
+		if n >= 0 && n-w < c {
+			c = n - w
+		}
+
+		r, err := fn(d, s, int(c))
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil && unsupported(err) {
+			// copy_file_range fails with files on different
+			// filesystems on older kernels and with pipes.
+			if w == 0 && cr {
+				cr, fn = false, sendfile
+				continue
+			}
+			return w, errFallback
+		}
+		if err != nil {
+			return w, err
+		}
+		if r == 0 {
+			break
+		}
+		w += int64(r)
+	}
+
+	return w, nil
+}
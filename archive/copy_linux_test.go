@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCopyFile(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	want := make([]byte, 1<<20+3)
+	rand.New(rand.NewSource(1)).Read(want)
+
+	src := path.Join(tmp, "src")
+	if err := ioutil.WriteFile(src, want, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// bytes copied by each method.
+	used := make(map[string]int)
+	cfr, sf := copyFileRange, sendfile
+	defer func() { copyFileRange, sendfile = cfr, sf }()
+	copyFileRange = func(dst, src int, n int) (int, error) {
+		r, err := cfr(dst, src, n)
+		used["copy_file_range"] += r
+		return r, err
+	}
+	sendfile = func(dst, src int, n int) (int, error) {
+		r, err := sf(dst, src, n)
+		used["sendfile"] += r
+		return r, err
+	}
+
+	copyTo := func(dst *os.File) {
+		f, err := os.Open(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		o := NewOutput(dst, 4096)
+		if _, err := o.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := o.ReadFrom(f); err != nil {
+			t.Fatal(err)
+		}
+		if err := o.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// copy_file_range between regular files.
+	dst, err := os.Create(path.Join(tmp, "dst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyTo(dst)
+	dst.Close()
+
+	b, err := ioutil.ReadFile(dst.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, append([]byte("x"), want...)) {
+		t.Error("file: contents differ")
+	}
+	if used["copy_file_range"] != len(want) || used["sendfile"] != 0 {
+		t.Errorf("file: copied %v", used)
+	}
+
+	// sendfile to a pipe.
+	used = make(map[string]int)
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(pr)
+		done <- b
+	}()
+	copyTo(pw)
+	pw.Close()
+
+	if b := <-done; !bytes.Equal(b, append([]byte("x"), want...)) {
+		t.Error("pipe: contents differ")
+	}
+	if used["sendfile"] != len(want) {
+		t.Errorf("pipe: copied %v", used)
+	}
+}
@@ -2,29 +2,73 @@ package archive
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/tlahdekorpi/archivegen/cpio"
 )
 
 type cpioWriter struct {
 	cw *cpio.Writer
+
+	// inodes of files with hardlinks.
+	inodes map[string]int64
 }
 
-func (w *cpioWriter) Close() error {
-	return w.cw.Close()
+func newCpioWriter(cw *cpio.Writer) *cpioWriter {
+	return &cpioWriter{cw: cw, inodes: make(map[string]int64)}
 }
 
-func (w *cpioWriter) Write(b []byte) (int, error) {
-	return w.cw.Write(b)
+func (w *cpioWriter) Close() error {
+	return w.cw.Close()
 }
 
-func (w *cpioWriter) WriteFile(file *os.File, hdr *Header) error {
+// WriteEntry writes an entry, cpio has no extended attributes
+// and they are not stored.
+func (w *cpioWriter) WriteEntry(hdr *Header, r io.Reader) error {
 	h, err := cpioHeader(hdr)
 	if err != nil {
 		return err
 	}
-	return w.cw.WriteFile(file, h)
+
+	switch hdr.Type {
+	case TypeDir:
+		h.Name += "/"
+		h.Size = 0
+	case TypeSymlink:
+		// symlink target is the content.
+		h.Size = int64(len(hdr.Linkname))
+		r = strings.NewReader(hdr.Linkname)
+	case TypeLink:
+		// hardlinks share the inode of the first link,
+		// which carries the data.
+		ino, ok := w.inodes[hdr.Linkname]
+		if !ok {
+			return fmt.Errorf("cpio: %s: link to unknown file %s", hdr.Name, hdr.Linkname)
+		}
+		h.Inode, h.Size = ino, 0
+		r = nil
+	}
+
+	if err := w.cw.WriteHeader(h); err != nil {
+		return err
+	}
+	if hdr.Links > 1 && hdr.Type == TypeRegular {
+		w.inodes[hdr.Name] = h.Inode
+	}
+
+	if r == nil {
+		return nil
+	}
+	if f, ok := r.(*os.File); ok && hdr.Sparse != nil {
+		// cpio has no sparse files, holes are zero-filled
+		// without reading them.
+		return copySparse(w.cw, f, hdr.Sparse, hdr.Size)
+	}
+	_, err = w.cw.ReadFrom(r)
+	return err
 }
 
 func cpioType(t FileType) int {
@@ -37,7 +81,7 @@ func cpioType(t FileType) int {
 		return cpio.TypeChar
 	case TypeBlock:
 		return cpio.TypeBlock
-	case TypeRegular:
+	case TypeRegular, TypeLink:
 		return cpio.TypeRegular
 	case TypeSymlink:
 		return cpio.TypeSymlink
@@ -61,6 +105,9 @@ func cpioHeader(a *Header) (*cpio.Header, error) {
 	if a.Time >= max {
 		panic("time")
 	}
+	if a.Devmajor >= max || a.Devminor >= max {
+		return nil, fmt.Errorf("cpio: %s: invalid device number", a.Name)
+	}
 
 	return &cpio.Header{
 		Name:  a.Name,
@@ -70,34 +117,9 @@ func cpioHeader(a *Header) (*cpio.Header, error) {
 		Mode:  int(a.Mode),
 		Type:  cpioType(a.Type),
 		Mtime: a.Time,
-	}, nil
-}
-
-func (w *cpioWriter) WriteHeader(hdr *Header) error {
-	if hdr.Type == TypeDir {
-		hdr.Name += "/"
-	}
-	h, err := cpioHeader(hdr)
-	if err != nil {
-		return err
-	}
-	return w.cw.WriteHeader(h)
-}
+		Links: a.Links,
 
-func (w *cpioWriter) Symlink(src, dst string, uid, gid, mode int) error {
-	hdr := &cpio.Header{
-		Name: dst,
-		Size: int64(len(src)),
-		Mode: mode,
-		Uid:  uid,
-		Gid:  gid,
-		Type: cpio.TypeSymlink,
-	}
-	if err := w.cw.WriteHeader(hdr); err != nil {
-		return err
-	}
-	if _, err := w.Write([]byte(src)); err != nil {
-		return err
-	}
-	return nil
+		Devmajor: int(a.Devmajor),
+		Devminor: int(a.Devminor),
+	}, nil
 }
@@ -0,0 +1,144 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/tlahdekorpi/archivegen/config"
+)
+
+// typeHardlink is the type of entries replaced by Dedup,
+// Src is the name of the first link.
+const typeHardlink = "h"
+
+// hardlinks share the inode, only files with identical
+// metadata can be linked.
+type inode struct {
+	size     int64
+	mode     int
+	uid, gid int
+	time     int64
+}
+
+func size(e config.Entry, m *members) (int64, error) {
+	switch e.Type {
+	case config.TypeRegular:
+		fs, err := os.Stat(e.Src)
+		if err != nil {
+			return 0, err
+		}
+		return fs.Size(), nil
+	case config.TypeArchive:
+		_, n, err := m.open(e)
+		return n, err
+	case config.TypeBase64:
+		return io.Copy(ioutil.Discard, base64.NewDecoder(
+			base64.StdEncoding, bytes.NewReader(e.Data),
+		))
+	case config.TypeCreate, config.TypeCreateNoEndl:
+		return int64(len(e.Data)), nil
+	}
+	return -1, nil
+}
+
+// linker sets the number of links of files written as hardlinks.
+type linker struct {
+	Writer
+	links map[string]int
+}
+
+func (w linker) WriteEntry(hdr *Header, r io.Reader) error {
+	if n, ok := w.links[hdr.Name]; ok {
+		hdr.Links = n
+	}
+	return w.Writer.WriteEntry(hdr, r)
+}
+
+// linked returns w setting the link counts of the files replaced by
+// Dedup.
+func (n *Node) linked(w Writer) Writer {
+	if n.links == nil {
+		return w
+	}
+	return linker{w, n.links}
+}
+
+func (n *Node) find(name string) *Node {
+	for _, v := range strings.Split(name, "/") {
+		if n = n.Map[v]; n == nil {
+			break
+		}
+	}
+	return n
+}
+
+// Dedup replaces files with the same contents and metadata by hardlinks
+// to the first one written, returning the number of bytes saved.
+func (n *Node) Dedup() (int64, error) {
+	var (
+		order []inode
+		files = make(map[inode][]config.Entry)
+	)
+	m := new(members)
+	defer m.close()
+	if err := n.walk("", func(e config.Entry) error {
+		s, err := size(e, m)
+		if s <= 0 || err != nil {
+			return err
+		}
+		k := inode{size: s, mode: e.Mode, uid: e.User, gid: e.Group, time: e.Time}
+		if files[k] == nil {
+			order = append(order, k)
+		}
+		files[k] = append(files[k], e)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	var saved int64
+	for _, k := range order {
+		if len(files[k]) < 2 {
+			continue
+		}
+
+		links := make(map[sum][]string)
+		for _, e := range files[k] {
+			s, err := content(e, m)
+			if err != nil {
+				return 0, err
+			}
+			links[s] = append(links[s], e.Dst)
+		}
+
+		for _, v := range links {
+			if len(v) < 2 {
+				continue
+			}
+			for i, name := range v {
+				x := n.find(name)
+				if x == nil {
+					return 0, fmt.Errorf("dedup: %s: not in tree", name)
+				}
+				if n.links == nil {
+					n.links = make(map[string]int)
+				}
+				n.links[name] = len(v)
+				if i == 0 {
+					continue
+				}
+				x.E.Type = typeHardlink
+				x.E.Src = v[0]
+				x.E.Data = nil
+				saved += k.size
+			}
+		}
+	}
+
+	return saved, nil
+}
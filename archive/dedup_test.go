@@ -0,0 +1,64 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/tlahdekorpi/archivegen/config"
+)
+
+func TestDedup(t *testing.T) {
+	c := new(config.Config)
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString(`
+c a - - - data
+c b/c - - - data
+c b/d 0600 - - data
+c e - - - other
+c f - - - data
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := Render(m)
+	n, err := root.Dedup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Errorf("saved %d bytes", n)
+	}
+
+	want := map[string]string{"b/c": "a", "f": "a"}
+	for _, format := range []string{"tar", "cpio"} {
+		b := new(bytes.Buffer)
+		w := NewWriter(format, b)
+		if err := root.Write(context.Background(), "", w); err != nil {
+			t.Fatal(format, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(format, err)
+		}
+
+		have := make(map[string]string)
+		if err := config.ReadArchive(b, func(e config.Entry, r io.Reader) error {
+			if e.Src != "" {
+				have[e.Dst] = e.Src
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(format, err)
+		}
+
+		if len(have) != len(want) {
+			t.Errorf("%s: links %v, want %v", format, have, want)
+		}
+		for k, v := range want {
+			if have[k] != v {
+				t.Errorf("%s: %s: link %q, want %q", format, k, have[k], v)
+			}
+		}
+	}
+}
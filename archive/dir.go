@@ -0,0 +1,259 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tlahdekorpi/archivegen/config"
+)
+
+// dirWriter writes entries into a directory. Without privileges the
+// ownership is recorded in a configuration file next to the directory
+// instead, archiving it recreates the tree with the intended owners.
+type dirWriter struct {
+	root string
+	priv bool
+
+	// directory modes are applied last to keep them writable.
+	dirs []*Header
+	meta []config.Entry
+}
+
+// NewDir returns a Writer creating the tree under root, root can not
+// be the filesystem root.
+func NewDir(root string) (Writer, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if root == "/" {
+		return nil, errors.New("dir: refusing to write into /")
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &dirWriter{root: root, priv: os.Geteuid() == 0}, nil
+}
+
+func init() {
+	// the tree is written below the root option instead of w.
+	Register("dir", func(_ io.Writer, opts Options) (Writer, error) {
+		if err := opts.Check("root"); err != nil {
+			return nil, err
+		}
+		if opts["root"] == "" {
+			return nil, errors.New("root option is required")
+		}
+		return NewDir(opts["root"])
+	})
+}
+
+// sidecar returns the name of the configuration file recording the
+// ownership of the absolute root, it is in the parent of root.
+func sidecar(root string) string {
+	return filepath.Join(filepath.Dir(root), filepath.Base(root)+".archive")
+}
+
+func fileMode(mode int64) os.FileMode {
+	m := os.FileMode(mode) & os.ModePerm
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// nofollow returns an error if p is a symlink, symlinks written into
+// the tree can point anywhere and are not followed.
+func nofollow(p string) error {
+	fs, err := os.Lstat(p)
+	if err != nil || fs.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+	return fmt.Errorf("dir: %s: is a symlink", p)
+}
+
+// path returns the path of name below the root, names outside of it
+// and below symlinks are refused.
+func (w *dirWriter) path(name string) (string, error) {
+	n := clean(name)
+	if n == ".." || strings.HasPrefix(n, "../") {
+		return "", fmt.Errorf("dir: %s: outside of %s", name, w.root)
+	}
+	p := w.root
+	x := strings.Split(n, "/")
+	for _, v := range x[:len(x)-1] {
+		p = filepath.Join(p, v)
+		if err := nofollow(p); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(p, x[len(x)-1]), nil
+}
+
+func (w *dirWriter) record(t, src string, hdr *Header) {
+	if w.priv {
+		return
+	}
+	w.meta = append(w.meta, config.Entry{
+		Type:  t,
+		Src:   src,
+		Dst:   clean(hdr.Name),
+		Mode:  int(hdr.Mode),
+		User:  hdr.Uid,
+		Group: hdr.Gid,
+	})
+}
+
+func (w *dirWriter) chown(p string, hdr *Header) error {
+	if !w.priv {
+		return nil
+	}
+	return os.Lchown(p, hdr.Uid, hdr.Gid)
+}
+
+// remove removes an existing non-directory at p.
+func remove(p string) error {
+	fs, err := os.Lstat(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fs.IsDir() {
+		return fmt.Errorf("dir: %s: is a directory", p)
+	}
+	return os.Remove(p)
+}
+
+// setattr applies the ownership, mode and time of hdr to p.
+func (w *dirWriter) setattr(p string, hdr *Header) error {
+	if err := w.chown(p, hdr); err != nil {
+		return err
+	}
+	// chown clears setuid and setgid bits.
+	if err := os.Chmod(p, fileMode(hdr.Mode)); err != nil {
+		return err
+	}
+	if hdr.Time > 0 {
+		t := time.Unix(hdr.Time, 0)
+		return os.Chtimes(p, t, t)
+	}
+	return nil
+}
+
+func (w *dirWriter) writeFile(p string, hdr *Header, r io.Reader) error {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return w.setattr(p, hdr)
+}
+
+// WriteEntry writes an entry into the directory, extended attributes
+// are not applied.
+func (w *dirWriter) WriteEntry(hdr *Header, r io.Reader) error {
+	p, err := w.path(hdr.Name)
+	if err != nil {
+		return err
+	}
+
+	switch hdr.Type {
+	case TypeDir:
+		if err := nofollow(p); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(p, 0700); err != nil {
+			return err
+		}
+		// existing directories may be read-only.
+		if err := os.Chmod(p, 0700); err != nil {
+			return err
+		}
+		w.dirs = append(w.dirs, hdr)
+		w.record(config.TypeDirectory, "", hdr)
+		return w.chown(p, hdr)
+
+	case TypeRegular:
+		if err := remove(p); err != nil {
+			return err
+		}
+		w.record(config.TypeRegular, p, hdr)
+		return w.writeFile(p, hdr, r)
+
+	case TypeLink:
+		t, err := w.path(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := remove(p); err != nil {
+			return err
+		}
+		w.record(config.TypeRegular, p, hdr)
+		return os.Link(t, p)
+
+	case TypeSymlink:
+		if err := remove(p); err != nil {
+			return err
+		}
+		if err := os.Symlink(hdr.Linkname, p); err != nil {
+			return err
+		}
+		w.record(config.TypeSymlink, hdr.Linkname, hdr)
+		return w.chown(p, hdr)
+	}
+
+	return fmt.Errorf("dir: %s: unsupported type %d", hdr.Name, hdr.Type)
+}
+
+// writeMeta appends the recorded entries to the sidecar, entries of
+// later runs replace earlier ones.
+func (w *dirWriter) writeMeta() error {
+	if len(w.meta) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(sidecar(w.root), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(f, 1, 1, 2, ' ', 0)
+	for _, v := range w.meta {
+		fmt.Fprintln(tw, v.Format())
+	}
+	if err := tw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (w *dirWriter) Close() error {
+	for i := len(w.dirs) - 1; i >= 0; i-- {
+		p, _ := w.path(w.dirs[i].Name)
+		if err := os.Chmod(p, fileMode(w.dirs[i].Mode)); err != nil {
+			return err
+		}
+	}
+	return w.writeMeta()
+}
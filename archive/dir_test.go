@@ -0,0 +1,165 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/tlahdekorpi/archivegen/config"
+)
+
+func TestDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	c := new(config.Config)
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString(`
+d ro 0555 1 2
+c ro/a 0640 3 4 data
+c ro/b 0640 3 4 data
+l a ro/c
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := Render(m)
+	if _, err := root.Dedup(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := path.Join(tmp, "out")
+	for i := 0; i < 2; i++ {
+		w, err := NewDir(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.(*dirWriter).priv = false
+		if err := root.Write(context.Background(), "", w); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for k, v := range map[string]os.FileMode{
+		"ro":   os.ModeDir | 0555,
+		"ro/a": 0640,
+		"ro/b": 0640,
+		"ro/c": os.ModeSymlink | 0777,
+	} {
+		fs, err := os.Lstat(path.Join(out, k))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fs.Mode() != v {
+			t.Errorf("%s: mode %v, want %v", k, fs.Mode(), v)
+		}
+	}
+
+	b, err := ioutil.ReadFile(path.Join(out, "ro/b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "data\n" {
+		t.Errorf("ro/b: content %q", b)
+	}
+
+	m, err = c.FromFiles(context.Background(), sidecar(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := Render(m).Check(bytes.NewReader(archiveOf(t, root)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range have {
+		t.Error(v)
+	}
+}
+
+func archiveOf(t *testing.T, n *Node) []byte {
+	b := new(bytes.Buffer)
+	w := NewWriter("tar", b)
+	if err := n.Write(context.Background(), "", w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDirSymlink(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	outside := path.Join(tmp, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(outside, "f"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewDir(path.Join(tmp, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.(*dirWriter).priv = false
+	for _, v := range []string{"x", "y/z"} {
+		if v == "y/z" {
+			if err := w.WriteEntry(&Header{Name: "y", Mode: 0755, Type: TypeDir}, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.WriteEntry(&Header{Name: v, Linkname: outside, Type: TypeSymlink}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, v := range []*Header{
+		{Name: "x/passwd", Mode: 0644, Type: TypeRegular},
+		{Name: "x/d", Mode: 0755, Type: TypeDir},
+		{Name: "x", Mode: 0700, Type: TypeDir},
+		{Name: "y/z/l", Linkname: "/etc", Type: TypeSymlink},
+		{Name: "h", Linkname: "x/f", Type: TypeLink},
+	} {
+		if err := w.WriteEntry(v, strings.NewReader("data")); err == nil {
+			t.Errorf("%s: followed symlink", v.Name)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := ioutil.ReadDir(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 1 {
+		t.Errorf("%d files outside", len(fs))
+	}
+	if fs, err := os.Stat(outside); err != nil || fs.Mode().Perm() != 0755 {
+		t.Errorf("outside: %v %v", fs.Mode(), err)
+	}
+}
+
+func TestDirRoot(t *testing.T) {
+	if _, err := NewDir("/"); err == nil {
+		t.Error("root / accepted")
+	}
+	if s := sidecar("/tmp/out"); s != "/tmp/out.archive" {
+		t.Errorf("sidecar %s", s)
+	}
+}
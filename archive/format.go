@@ -0,0 +1,125 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/tlahdekorpi/archivegen/cpio"
+)
+
+// Options are format specific settings given as key=value pairs.
+type Options map[string]string
+
+// Check returns an error for options not in keys, factories use it to
+// reject options of other formats.
+func (o Options) Check(keys ...string) error {
+	for k := range o {
+		var ok bool
+		for _, v := range keys {
+			if ok = k == v; ok {
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("unknown option: %s", k)
+		}
+	}
+	return nil
+}
+
+// Bool returns the boolean value of key, or def if it is not set.
+func (o Options) Bool(key string, def bool) (bool, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("option %s: %v", key, err)
+	}
+	return b, nil
+}
+
+// Factory returns a Writer writing an archive to w.
+type Factory func(w io.Writer, opts Options) (Writer, error)
+
+var (
+	formatsMu sync.RWMutex
+	formats   = make(map[string]Factory)
+)
+
+// Register makes a format available by name to New. It panics if
+// a format is registered twice or factory is nil.
+func Register(name string, factory Factory) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if factory == nil {
+		panic("archive: Register factory is nil")
+	}
+	if _, ok := formats[name]; ok {
+		panic("archive: Register called twice for format " + name)
+	}
+	formats[name] = factory
+}
+
+// Formats returns the sorted names of the registered formats.
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	r := make([]string, 0, len(formats))
+	for k := range formats {
+		r = append(r, k)
+	}
+	sort.Strings(r)
+	return r
+}
+
+// New returns a Writer for the named format writing to w.
+func New(format string, w io.Writer, opts Options) (Writer, error) {
+	formatsMu.RLock()
+	f, ok := formats[format]
+	formatsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+	r, err := f(w, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", format, err)
+	}
+	return r, nil
+}
+
+func newTar(w io.Writer, opts Options, format string) (*tarWriter, error) {
+	if err := opts.Check("sparse"); err != nil {
+		return nil, err
+	}
+	sparse, err := opts.Bool("sparse", true)
+	if err != nil {
+		return nil, err
+	}
+	r := newTarWriter(w, tarFormats[format])
+	r.sparse = sparse
+	return r, nil
+}
+
+func newCpio(w io.Writer, opts Options, offset, inode int64) (*cpioWriter, error) {
+	if err := opts.Check(); err != nil {
+		return nil, err
+	}
+	return newCpioWriter(cpio.NewWriterOffset(w, offset, inode)), nil
+}
+
+func init() {
+	for k := range tarFormats {
+		format := k
+		Register(format, func(w io.Writer, opts Options) (Writer, error) {
+			return newTar(w, opts, format)
+		})
+	}
+	Register("cpio", func(w io.Writer, opts Options) (Writer, error) {
+		return newCpio(w, opts, 0, 0)
+	})
+}
@@ -0,0 +1,71 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+type countWriter struct {
+	Writer
+	n int
+}
+
+func (w *countWriter) WriteEntry(hdr *Header, r io.Reader) error {
+	w.n++
+	return w.Writer.WriteEntry(hdr, r)
+}
+
+func registered(name string) bool {
+	for _, v := range Formats() {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRegister(t *testing.T) {
+	// formats can't be removed, with -count the format exists.
+	if !registered("test-count") {
+		Register("test-count", func(w io.Writer, opts Options) (Writer, error) {
+			if err := opts.Check(); err != nil {
+				return nil, err
+			}
+			return &countWriter{Writer: NewWriter("tar", w)}, nil
+		})
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("no panic on duplicate format")
+			}
+		}()
+		Register("test-count", func(io.Writer, Options) (Writer, error) { return nil, nil })
+	}()
+
+	for _, v := range []string{"test-count", "tar", "cpio", "dir"} {
+		if !registered(v) {
+			t.Errorf("%s not in formats %v", v, Formats())
+		}
+	}
+
+	if _, err := New("test-count", nil, Options{"x": "1"}); err == nil {
+		t.Error("unknown option accepted")
+	}
+	if _, err := New("test-missing", nil, nil); err == nil {
+		t.Error("unknown format accepted")
+	}
+
+	w, err := New("test-count", new(bytes.Buffer), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeDir(w, "a", 0755, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := w.(*countWriter).n; n != 1 {
+		t.Errorf("%d headers", n)
+	}
+}
@@ -0,0 +1,202 @@
+package archive
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/tlahdekorpi/archivegen/config"
+)
+
+// members reads the contents of TypeArchive entries. The last archive
+// is kept open as members are mostly requested in the order of the
+// archive. An archive requested again is read once more in its own
+// order and the contents of its members spooled into a temporary file,
+// which is read for the rest of the pass.
+type members struct {
+	src   string
+	f     *os.File
+	r     *config.ArchiveReader
+	i     int // index of the current member.
+	read  map[string]bool
+	spool map[string]*spool
+}
+
+// spool is the contents of the members of an archive by index.
+type spool struct {
+	f *os.File
+	m map[int]span
+}
+
+type span struct {
+	off, n int64
+}
+
+// open returns the contents of the member of e and their size, the
+// reader is valid until the next call.
+func (m *members) open(e config.Entry) (io.Reader, int64, error) {
+	i, err := strconv.Atoi(string(e.Data))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: invalid member %q", e.Src, e.Data)
+	}
+
+	if s, ok := m.spool[e.Src]; ok {
+		return s.open(e.Src, i)
+	}
+
+	if m.r != nil && m.src == e.Src && i > m.i {
+		if n, err := m.find(i); err != io.EOF {
+			return m.r, n, err
+		}
+	}
+
+	if m.read[e.Src] {
+		s, err := m.spooled(e.Src)
+		if err != nil {
+			return nil, 0, err
+		}
+		return s.open(e.Src, i)
+	}
+
+	if err := m.end(); err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(e.Src)
+	if err != nil {
+		return nil, 0, err
+	}
+	r, err := config.NewArchiveReader(f)
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	if m.read == nil {
+		m.read = make(map[string]bool)
+	}
+	m.src, m.f, m.r, m.i = e.Src, f, r, -1
+	m.read[e.Src] = true
+
+	n, err := m.find(i)
+	if err == io.EOF {
+		return nil, 0, fmt.Errorf("%s: member %d not found", e.Src, i)
+	}
+	return m.r, n, err
+}
+
+// find advances to the member with the contents at index i.
+func (m *members) find(i int) (int64, error) {
+	for {
+		e, n, err := m.r.Next()
+		if err != nil {
+			return 0, err
+		}
+		if e.Type == config.TypeRegular && e.Src == "" && m.r.Index() == i {
+			m.i = i
+			return n, nil
+		}
+	}
+}
+
+// spooled reads the archive src into a spool.
+func (m *members) spooled(src string) (*spool, error) {
+	if m.src == src {
+		if err := m.end(); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := config.NewArchiveReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	t, err := ioutil.TempFile("", "archivegen")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(t.Name())
+
+	s := &spool{f: t, m: make(map[int]span)}
+
+	var off int64
+	for {
+		e, _, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		if e.Type != config.TypeRegular || e.Src != "" {
+			continue
+		}
+		n, err := io.Copy(t, r)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		s.m[r.Index()] = span{off, n}
+		off += n
+	}
+
+	if m.spool == nil {
+		m.spool = make(map[string]*spool)
+	}
+	m.spool[src] = s
+	return s, nil
+}
+
+func (s *spool) open(src string, i int) (io.Reader, int64, error) {
+	v, ok := s.m[i]
+	if !ok {
+		return nil, 0, fmt.Errorf("%s: member %d not found", src, i)
+	}
+	return io.NewSectionReader(s.f, v.off, v.n), v.n, nil
+}
+
+// close closes the archive read and the spools.
+func (m *members) close() error {
+	for k, v := range m.spool {
+		v.f.Close()
+		delete(m.spool, k)
+	}
+	return m.end()
+}
+
+// end closes the archive read.
+func (m *members) end() error {
+	if m.f == nil {
+		return nil
+	}
+	m.r.Close()
+	err := m.f.Close()
+	m.src, m.f, m.r = "", nil, nil
+	return err
+}
+
+// base64 returns e with the contents of its member as TypeBase64.
+func (m *members) base64(e config.Entry) (config.Entry, error) {
+	r, _, err := m.open(e)
+	if err != nil {
+		return e, err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return e, err
+	}
+	e.Data = make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+	base64.StdEncoding.Encode(e.Data, b)
+	e.Type = config.TypeBase64
+	e.Src = e.Dst
+	return e, nil
+}
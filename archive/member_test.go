@@ -0,0 +1,161 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/tlahdekorpi/archivegen/config"
+)
+
+func TestMembers(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_members")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// members are written in a different order than in the source.
+	b := new(bytes.Buffer)
+	tw := tar.NewWriter(b)
+	for _, v := range []struct {
+		name, link, data string
+	}{
+		{name: "z", data: "zz"},
+		{name: "y", link: "z"},
+		{name: "a", data: "a"},
+	} {
+		hdr := &tar.Header{Name: v.name, Mode: 0644, Size: int64(len(v.data))}
+		if v.link != "" {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = v.link
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(v.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	src := path.Join(tmp, "src.tar")
+	if err := ioutil.WriteFile(src, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := new(config.Config)
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString("A "+src+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := Render(m)
+
+	want := map[string]string{"a": "a", "y": "zz", "z": "zz"}
+	for _, workers := range []int{0, 2} {
+		b := new(bytes.Buffer)
+		w := NewWriter("tar", b)
+		if err := root.WriteAhead(context.Background(), "", w, workers); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		have := make(map[string]string)
+		if err := config.ReadArchive(b, func(e config.Entry, r io.Reader) error {
+			d, err := ioutil.ReadAll(r)
+			have[e.Dst] = string(d)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range want {
+			if have[k] != v {
+				t.Errorf("workers %d: %s: %q, want %q", workers, k, have[k], v)
+			}
+		}
+
+		r, err := root.Check(bytes.NewReader(archiveOf(t, root)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r) > 0 {
+			t.Errorf("check: %v", r)
+		}
+	}
+
+	var out bytes.Buffer
+	tab := tabwriter.NewWriter(&out, 1, 1, 2, ' ', 0)
+	if err := root.Print("", tab, &out, false); err != nil {
+		t.Fatal(err)
+	}
+	tab.Flush()
+	if s := out.String(); !strings.Contains(s, "b64  y    0644  0  0  eno=") {
+		t.Errorf("print:\n%s", s)
+	}
+
+	n, err := root.Dedup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("dedup saved %d, want 2", n)
+	}
+}
+
+// TestMembersRepeated writes a name repeated in the source archive with
+// the contents of its last member.
+func TestMembersRepeated(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_members")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	b := new(bytes.Buffer)
+	tw := tar.NewWriter(b)
+	for _, v := range []string{"old", "new"} {
+		if err := tw.WriteHeader(&tar.Header{Name: "x", Mode: 0644, Size: int64(len(v))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	src := path.Join(tmp, "src.tar")
+	if err := ioutil.WriteFile(src, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := new(config.Config)
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString("A "+src+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var have string
+	if err := config.ReadArchive(bytes.NewReader(archiveOf(t, Render(m))), func(e config.Entry, r io.Reader) error {
+		d, err := ioutil.ReadAll(r)
+		have = e.Dst + ":" + string(d)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if have != "x:new" {
+		t.Errorf("have %q", have)
+	}
+}
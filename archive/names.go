@@ -0,0 +1,66 @@
+package archive
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Names maps user and group ids to names.
+type Names struct {
+	User  map[int]string
+	Group map[int]string
+}
+
+// Namer is implemented by writers recording user and group names.
+type Namer interface {
+	SetNames(*Names)
+}
+
+// readIDs reads names and ids from a passwd or group file.
+func readIDs(file string) (map[int]string, error) {
+	r := make(map[int]string)
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		x := strings.Split(s.Text(), ":")
+		if len(x) < 3 || len(x[0]) == 0 || x[0][0] == '#' {
+			continue
+		}
+		id, err := strconv.Atoi(x[2])
+		if err != nil {
+			continue
+		}
+		// first entry wins, same as getpwuid.
+		if _, exists := r[id]; !exists {
+			r[id] = x[0]
+		}
+	}
+
+	return r, s.Err()
+}
+
+// ReadNames reads user and group names from etc/passwd and etc/group
+// below prefix.
+func ReadNames(prefix string) (*Names, error) {
+	u, err := readIDs(path.Join("/", prefix, "etc/passwd"))
+	if err != nil {
+		return nil, err
+	}
+	g, err := readIDs(path.Join("/", prefix, "etc/group"))
+	if err != nil {
+		return nil, err
+	}
+	return &Names{User: u, Group: g}, nil
+}
@@ -0,0 +1,79 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestReadNames(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// missing files are empty.
+	n, err := ReadNames(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.User) != 0 || len(n.Group) != 0 {
+		t.Errorf("names %v", n)
+	}
+
+	if err := os.Mkdir(path.Join(tmp, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{
+		"passwd": "# comment\nroot:x:0:0::/root:/bin/sh\ntoor:x:0:0::/root:/bin/sh\nbin:x:1:1\n:x:2:2\ninvalid:x:x:3\nshort:x\n",
+		"group":  "root:x:0:\nwheel:x:10:root\n",
+	} {
+		if err := ioutil.WriteFile(path.Join(tmp, "etc", k), []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err = ReadNames(tmp); err != nil {
+		t.Fatal(err)
+	}
+	want := &Names{
+		User:  map[int]string{0: "root", 1: "bin"},
+		Group: map[int]string{0: "root", 10: "wheel"},
+	}
+	if !reflect.DeepEqual(n, want) {
+		t.Errorf("names %v, want %v", n, want)
+	}
+
+	b := new(bytes.Buffer)
+	w := NewWriter("tar", b)
+	w.(Namer).SetNames(n)
+	for _, v := range []Header{
+		{Name: "a", Uid: 0, Gid: 10, Type: TypeRegular},
+		{Name: "b", Uid: 1, Gid: 2, Type: TypeRegular},
+		{Name: "c", Uid: 1, Gid: 0, Uname: "user", Type: TypeRegular},
+	} {
+		hdr := v
+		if err := w.WriteEntry(&hdr, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(b)
+	for _, v := range [][2]string{{"root", "wheel"}, {"bin", ""}, {"user", "root"}} {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Uname != v[0] || hdr.Gname != v[1] {
+			t.Errorf("%s: %s:%s, want %s:%s", hdr.Name, hdr.Uname, hdr.Gname, v[0], v[1])
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package archive
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"sort"
@@ -8,14 +10,26 @@ import (
 	"text/tabwriter"
 
 	"github.com/tlahdekorpi/archivegen/config"
+	"github.com/tlahdekorpi/archivegen/progress"
 )
 
 type Node struct {
 	Map map[string]*Node
 	E   config.Entry
+
+	// number of links of files replaced by Dedup.
+	links map[string]int
+}
+
+// Print writes the tree to w as configuration entries, heredocs are
+// written to bw. Members of archives are printed as b64 entries.
+func (n *Node) Print(p string, w *tabwriter.Writer, bw io.Writer, b64 bool) error {
+	a := new(members)
+	defer a.close()
+	return n.print(p, w, bw, b64, a)
 }
 
-func (n *Node) Print(p string, w *tabwriter.Writer, bw io.Writer, b64 bool) {
+func (n *Node) print(p string, w *tabwriter.Writer, bw io.Writer, b64 bool, a *members) error {
 	var (
 		d []string
 		m []string
@@ -35,6 +49,12 @@ func (n *Node) Print(p string, w *tabwriter.Writer, bw io.Writer, b64 bool) {
 
 		E := n.Map[v].E
 
+		if E.Type == config.TypeArchive {
+			var err error
+			if E, err = a.base64(E); err != nil {
+				return err
+			}
+		}
 		if b64 {
 			E = E.Base64()
 		}
@@ -67,8 +87,11 @@ func (n *Node) Print(p string, w *tabwriter.Writer, bw io.Writer, b64 bool) {
 		n.Map[v].E.Dst = dn
 		fmt.Fprintln(w, n.Map[v].E.Format())
 
-		n.Map[v].Print(dn, w, bw, b64)
+		if err := n.Map[v].print(dn, w, bw, b64, a); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 func (n *Node) Add(name string, file config.Entry) *Node {
@@ -96,16 +119,17 @@ func mapsort(m map[string]*Node) []string {
 	return r
 }
 
-func (n *Node) Write(p string, w Writer) error {
+// walk calls fn for every entry of the tree in archive order.
+func (n *Node) walk(p string, fn func(config.Entry) error) error {
 	d := make([]string, 0)
 
-	// write all non-directories
+	// all non-directories first
 	for _, v := range mapsort(n.Map) {
 		if n.Map[v].E.Type == config.TypeDirectory {
 			d = append(d, v)
 			continue
 		}
-		if err := Write(n.Map[v].E, w); err != nil {
+		if err := fn(n.Map[v].E); err != nil {
 			return err
 		}
 	}
@@ -120,7 +144,7 @@ func (n *Node) Write(p string, w Writer) error {
 
 		if dn == "" {
 			// next entry
-			if err := n.Map[v].Write(v, w); err != nil {
+			if err := n.Map[v].walk(v, fn); err != nil {
 				return err
 			}
 			continue
@@ -129,11 +153,11 @@ func (n *Node) Write(p string, w Writer) error {
 		de := n.Map[v].E
 		de.Src = dn
 
-		if err := Write(de, w); err != nil {
+		if err := fn(de); err != nil {
 			return err
 		}
 
-		if err := n.Map[v].Write(dn, w); err != nil {
+		if err := n.Map[v].walk(dn, fn); err != nil {
 			return err
 		}
 	}
@@ -141,6 +165,114 @@ func (n *Node) Write(p string, w Writer) error {
 	return nil
 }
 
+// reporter reports the entries written through it as progress events.
+type reporter struct {
+	Writer
+	ctx   context.Context
+	n     int
+	bytes int64
+}
+
+func (r *reporter) WriteEntry(hdr *Header, rd io.Reader) error {
+	if err := r.Writer.WriteEntry(hdr, rd); err != nil {
+		return err
+	}
+	r.n++
+	r.bytes += hdr.Size
+	progress.Report(r.ctx, progress.Event{
+		Stage:   progress.Write,
+		Entries: r.n,
+		Bytes:   r.bytes,
+		File:    hdr.Name,
+	})
+	return nil
+}
+
+func report(ctx context.Context, w Writer) Writer {
+	if !progress.Enabled(ctx) {
+		return w
+	}
+	return &reporter{Writer: w, ctx: ctx}
+}
+
+// outputError is an error of the archive being written.
+type outputError struct{ err error }
+
+func (e outputError) Error() string { return e.err.Error() }
+
+// output marks the errors of a Writer as outputErrors.
+type output struct{ Writer }
+
+func (w output) WriteEntry(hdr *Header, r io.Reader) error {
+	if err := w.Writer.WriteEntry(hdr, r); err != nil {
+		return outputError{err}
+	}
+	return nil
+}
+
+func (w output) Close() error {
+	return w.Writer.Close()
+}
+
+// skip collects the errors of entries when keep is set.
+type skip struct {
+	keep bool
+	errs []string
+}
+
+func (s *skip) entry(e config.Entry, err error) error {
+	if err == nil {
+		return nil
+	}
+	if o, ok := err.(outputError); ok {
+		return o.err
+	}
+	if !s.keep {
+		return err
+	}
+	r := fmt.Sprintf("%s: %v", e.Dst, err)
+	if e.File != "" {
+		r = e.File + ": " + r
+	}
+	if e.Line > 0 {
+		r = fmt.Sprintf("%s, line %d", r, e.Line)
+	}
+	s.errs = append(s.errs, r)
+	return nil
+}
+
+func (s *skip) err() error {
+	switch len(s.errs) {
+	case 0:
+		return nil
+	case 1:
+		return errors.New(s.errs[0])
+	}
+	return errors.New("\n  " + strings.Join(s.errs, "\n  "))
+}
+
+// Write writes the tree to w, stopping when ctx is done.
+func (n *Node) Write(ctx context.Context, p string, w Writer) error {
+	return n.write(ctx, p, w, &skip{})
+}
+
+func (n *Node) write(ctx context.Context, p string, w Writer, s *skip) error {
+	w = report(ctx, n.linked(w))
+	m := new(members)
+	defer m.close()
+	return n.walk(p, func(e config.Entry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return s.entry(e, writeEntry(e, w, m))
+	})
+}
+
+// WriteMap writes the tree of m to w, stopping when ctx is done.
+func WriteMap(ctx context.Context, m *config.Map, w Writer) error {
+	return Render(m).Write(ctx, "", w)
+}
+
 func Render(cfg *config.Map) *Node {
 	root := &Node{
 		E: config.Entry{
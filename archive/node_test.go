@@ -0,0 +1,115 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/tlahdekorpi/archivegen/config"
+	"github.com/tlahdekorpi/archivegen/progress"
+)
+
+func TestWriteMap(t *testing.T) {
+	var c config.Config
+	m, err := c.NewBuilder(context.Background()).
+		Dir("a", config.Mode(0700)).
+		Create("a/b", []byte("data"), config.Owner(1, 2)).
+		Map()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := new(bytes.Buffer)
+	w := NewWriter("tar", b)
+	if err := WriteMap(context.Background(), m, w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(b)
+	for _, v := range []tar.Header{
+		{Name: "a/", Mode: 0700},
+		{Name: "a/b", Mode: 0644, Uid: 1, Gid: 2, Size: 4},
+	} {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != v.Name || hdr.Mode != v.Mode ||
+			hdr.Uid != v.Uid || hdr.Gid != v.Gid || hdr.Size != v.Size {
+			t.Errorf("header %+v, want %+v", hdr, v)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := WriteMap(ctx, m, NewWriter("tar", b)); err != context.Canceled {
+		t.Errorf("cancel: %v", err)
+	}
+}
+
+func TestWriteProgress(t *testing.T) {
+	var c config.Config
+	m, err := c.NewBuilder(context.Background()).
+		Create("a", []byte("data")).
+		Create("b/c", []byte("abc")).
+		Map()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r []progress.Event
+	ctx := progress.WithFunc(context.Background(), func(e progress.Event) {
+		r = append(r, e)
+	})
+	if err := Render(m).WriteAhead(ctx, "", NewWriter("tar", new(bytes.Buffer)), 2); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []progress.Event{
+		{Stage: progress.Write, Entries: 1, Bytes: 4, File: "a"},
+		{Stage: progress.Write, Entries: 2, Bytes: 4, File: "b"},
+		{Stage: progress.Write, Entries: 3, Bytes: 7, File: "b/c"},
+	}
+	if len(r) != len(want) {
+		t.Fatalf("events %+v, want %+v", r, want)
+	}
+	for k, v := range want {
+		if r[k] != v {
+			t.Errorf("event %d: %+v, want %+v", k, r[k], v)
+		}
+	}
+}
+
+func TestWriteAll(t *testing.T) {
+	var c config.Config
+	m, err := c.NewBuilder(context.Background()).
+		File("/nonexistent/a", "a").
+		Create("b", []byte("data")).
+		File("/nonexistent/c", "c").
+		Map()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := Render(m)
+	if err := root.WriteAhead(context.Background(), "", NewWriter("tar", new(bytes.Buffer)), 2); err == nil {
+		t.Fatal("no error")
+	}
+
+	b := new(bytes.Buffer)
+	err = root.WriteAll(context.Background(), "", NewWriter("tar", b), 2)
+	want := "\n  a: open /nonexistent/a: no such file or directory, line 1" +
+		"\n  c: open /nonexistent/c: no such file or directory, line 3"
+	if err == nil || err.Error() != want {
+		t.Errorf("error: %v", err)
+	}
+
+	hdr, err := tar.NewReader(b).Next()
+	if err != nil || hdr.Name != "b" {
+		t.Errorf("archive: %v %v", hdr, err)
+	}
+}
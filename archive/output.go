@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// Output is a buffered archive destination. File data is handed to
+// the kernel when possible instead of copying it through the buffer.
+type Output struct {
+	f   *os.File
+	buf *bufio.Writer
+}
+
+// NewOutput returns an Output writing to f with a buffer of size bytes,
+// writes are not buffered if size is less than one.
+func NewOutput(f *os.File, size int) *Output {
+	o := &Output{f: f}
+	if size > 0 {
+		o.buf = bufio.NewWriterSize(f, size)
+	}
+	return o
+}
+
+func (o *Output) Write(b []byte) (int, error) {
+	if o.buf == nil {
+		return o.f.Write(b)
+	}
+	return o.buf.Write(b)
+}
+
+func (o *Output) Flush() error {
+	if o.buf == nil {
+		return nil
+	}
+	return o.buf.Flush()
+}
+
+// source returns the file behind r and the number of bytes to copy,
+// n is negative when copying until the end of file.
+func source(r io.Reader) (f *os.File, n int64) {
+	n = -1
+	for {
+		switch x := r.(type) {
+		case *os.File:
+			return x, n
+		case *io.LimitedReader:
+			if n < 0 || x.N < n {
+				n = x.N
+			}
+			r = x.R
+		default:
+			return nil, n
+		}
+	}
+}
+
+// consume subtracts n from the limits of r.
+func consume(r io.Reader, n int64) {
+	for {
+		x, ok := r.(*io.LimitedReader)
+		if !ok {
+			return
+		}
+		x.N -= n
+		r = x.R
+	}
+}
+
+// small files are cheaper to copy than flushing the buffer.
+const minCopy = 64 << 10
+
+func (o *Output) ReadFrom(r io.Reader) (int64, error) {
+	f, n := source(r)
+	if f == nil || n >= 0 && n < minCopy {
+		return o.copy(r)
+	}
+
+	if err := o.Flush(); err != nil {
+		return 0, err
+	}
+
+	w, err := copyFile(o.f, f, n)
+	consume(r, w)
+	if err != errFallback {
+		return w, err
+	}
+
+	c, err := o.copy(r)
+	return w + c, err
+}
+
+// copy copies r through the buffer.
+func (o *Output) copy(r io.Reader) (int64, error) {
+	if o.buf == nil {
+		return io.Copy(o.f, r)
+	}
+	return o.buf.ReadFrom(r)
+}
@@ -0,0 +1,75 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/tlahdekorpi/archivegen/config"
+)
+
+func TestOutput(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	want := make([]byte, 1<<20+3)
+	rand.New(rand.NewSource(1)).Read(want)
+
+	src := path.Join(tmp, "src")
+	if err := ioutil.WriteFile(src, want, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{"tar", "cpio"} {
+		file := path.Join(tmp, "out."+format)
+		f, err := os.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		o := NewOutput(f, 4096)
+		w := NewWriter(format, o)
+		for _, v := range []func() error{
+			func() error { return createFile(w, config.Entry{Dst: "a", Mode: 0644, Data: []byte("a")}) },
+			func() error { return writeFile(w, config.Entry{Src: src, Dst: "b", Mode: 0644}) },
+			func() error { return writeFile(w, config.Entry{Src: src, Dst: "c", Mode: 0644}) },
+			w.Close, o.Flush, f.Close,
+		} {
+			if err := v(); err != nil {
+				t.Fatal(format, err)
+			}
+		}
+
+		f, err = os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var n int
+		if err := config.ReadArchive(f, func(e config.Entry, r io.Reader) error {
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if e.Dst != "a" && !bytes.Equal(b, want) {
+				t.Errorf("%s: %s: contents differ", format, e.Dst)
+			}
+			n++
+			return nil
+		}); err != nil {
+			t.Fatal(format, err)
+		}
+		f.Close()
+
+		if n != 3 {
+			t.Errorf("%s: %d entries", format, n)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package archive
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/tlahdekorpi/archivegen/config"
+)
+
+// files up to this size are read into memory ahead of the writer.
+const readaheadSize = 256 << 10
+
+type ahead struct {
+	f    *os.File
+	fs   os.FileInfo
+	data []byte
+	err  error
+}
+
+func (a *ahead) close() {
+	if a.f != nil {
+		a.f.Close()
+	}
+}
+
+func readahead(src string) (r ahead) {
+	if r.f, r.err = os.Open(src); r.err != nil {
+		return
+	}
+	if r.fs, r.err = r.f.Stat(); r.err != nil {
+		return
+	}
+	if r.fs.Size() > readaheadSize {
+		return
+	}
+	r.data = make([]byte, r.fs.Size())
+	if _, r.err = io.ReadFull(r.f, r.data); r.err == nil {
+		r.f.Close()
+		r.f = nil
+	}
+	return
+}
+
+func (a *ahead) write(w Writer, e config.Entry) error {
+	defer a.close()
+	if a.err != nil {
+		return a.err
+	}
+	if a.f == nil {
+		e.Data = a.data
+		return createFile(w, e)
+	}
+	return writeOpen(w, a.f, a.fs, e)
+}
+
+// WriteAhead writes the tree in the same order as Write, with n workers
+// opening and reading upcoming files while the current one is written.
+func (n *Node) WriteAhead(ctx context.Context, p string, w Writer, workers int) error {
+	return n.writeAhead(ctx, p, w, workers, &skip{})
+}
+
+// WriteAll is WriteAhead continuing past entries that cannot be read,
+// their errors are returned together after the rest of the tree is
+// written. Errors of w stop writing.
+func (n *Node) WriteAll(ctx context.Context, p string, w Writer, workers int) error {
+	s := &skip{keep: true}
+	if err := n.writeAhead(ctx, p, output{w}, workers, s); err != nil {
+		return err
+	}
+	return s.err()
+}
+
+func (n *Node) writeAhead(ctx context.Context, p string, w Writer, workers int, s *skip) error {
+	if workers < 1 {
+		return n.write(ctx, p, w, s)
+	}
+	w = report(ctx, n.linked(w))
+
+	var e []config.Entry
+	if err := n.walk(p, func(v config.Entry) error {
+		e = append(e, v)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	var (
+		wg   sync.WaitGroup
+		res  = make([]chan ahead, len(e))
+		jobs = make(chan int)
+		done = make(chan struct{})
+
+		// bounds the number of files open or in memory.
+		window = make(chan struct{}, workers*4)
+	)
+
+	for k, v := range e {
+		if v.Type == config.TypeRegular {
+			res[k] = make(chan ahead, 1)
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range jobs {
+				res[k] <- readahead(e[k].Src)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		for k := range res {
+			if res[k] == nil {
+				continue
+			}
+			select {
+			case window <- struct{}{}:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- k:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	defer func() {
+		close(done)
+		wg.Wait()
+		for _, v := range res {
+			select {
+			case a := <-v:
+				a.close()
+			default:
+			}
+		}
+	}()
+
+	m := new(members)
+	defer m.close()
+	for k, v := range e {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if res[k] == nil {
+			if err := s.entry(v, writeEntry(v, w, m)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		a := <-res[k]
+		<-window
+		if err := s.entry(v, a.write(w, v)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/tlahdekorpi/archivegen/config"
+)
+
+func TestWriteAhead(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_readahead")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	cfg := new(bytes.Buffer)
+	for i := 0; i < 64; i++ {
+		file := path.Join(tmp, strconv.Itoa(i))
+		data := bytes.Repeat([]byte{byte(i)}, i*readaheadSize/16)
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(cfg, "f %s a/%d\n", file, i)
+	}
+
+	m, err := new(config.Config).FromReader(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := Render(m)
+
+	var want, have bytes.Buffer
+	for _, v := range []struct {
+		b *bytes.Buffer
+		n int
+	}{{&want, 0}, {&have, 4}} {
+		w := NewWriter("tar", v.b)
+		if err := root.WriteAhead(context.Background(), "", w, v.n); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(want.Bytes(), have.Bytes()) {
+		t.Error("archives differ")
+	}
+}
@@ -0,0 +1,211 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SparseEntry is a region of data in a sparse file.
+type SparseEntry struct {
+	Offset int64
+	Length int64
+}
+
+var zeroChunk [32 << 10]byte
+
+func zeros(w io.Writer, n int64) error {
+	for n > 0 {
+		l := int64(len(zeroChunk))
+		if n < l {
+			l = n
+		}
+		if _, err := w.Write(zeroChunk[:l]); err != nil {
+			return err
+		}
+		n -= l
+	}
+	return nil
+}
+
+// copySparse writes size bytes of file to w reading only the data
+// regions of sp, holes are written as zeros.
+func copySparse(w io.Writer, file *os.File, sp []SparseEntry, size int64) error {
+	var off int64
+	for _, v := range sp {
+		if err := zeros(w, v.Offset-off); err != nil {
+			return err
+		}
+		if _, err := file.Seek(v.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, file, v.Length); err != nil {
+			return err
+		}
+		off = v.Offset + v.Length
+	}
+	return zeros(w, size-off)
+}
+
+// sparseRegions returns the data regions of file, or nil
+// if the file has no holes worth preserving.
+func sparseRegions(file *os.File, size int64) ([]SparseEntry, error) {
+	if size < blockSize {
+		return nil, nil
+	}
+
+	r, err := dataRegions(file, size)
+	if r == nil || err != nil {
+		return nil, err
+	}
+
+	var n int64
+	for _, v := range r {
+		n += v.Length
+	}
+	if size-n < blockSize {
+		return nil, nil
+	}
+
+	// record the size with an empty region at the end.
+	if l := len(r); l == 0 || r[l-1].Offset+r[l-1].Length < size {
+		r = append(r, SparseEntry{size, 0})
+	}
+	return r, nil
+}
+
+func paxRecord(k, v string) string {
+	// length of the record includes the length itself.
+	n := len(k) + len(v) + 3
+	n += len(strconv.Itoa(n))
+	r := fmt.Sprintf("%d %s=%s\n", n, k, v)
+	if len(r) != n {
+		r = fmt.Sprintf("%d %s=%s\n", len(r), k, v)
+	}
+	return r
+}
+
+func octal(b []byte, n int64) bool {
+	s := strconv.FormatInt(n, 8)
+	if n < 0 || len(s) > len(b)-1 {
+		return false
+	}
+	copy(b, strings.Repeat("0", len(b)-1-len(s))+s)
+	return true
+}
+
+func ascii(b []byte, s string) bool {
+	for _, c := range s {
+		if c >= 0x80 || c == 0 {
+			return false
+		}
+	}
+	if len(s) > len(b) {
+		return false
+	}
+	copy(b, s)
+	return true
+}
+
+// ustarBlock returns an USTAR header block, fields that don't fit are
+// added to the PAX records in pax.
+func ustarBlock(hdr *Header, flag byte, size int64, pax map[string]string) []byte {
+	b := make([]byte, blockSize)
+
+	name := hdr.Name
+	if len(name) > 100 {
+		name = name[len(name)-100:]
+	}
+	copy(b[0:100], name)
+
+	octal(b[100:108], hdr.Mode&07777)
+	if !octal(b[108:116], int64(hdr.Uid)) {
+		pax["uid"] = strconv.Itoa(hdr.Uid)
+	}
+	if !octal(b[116:124], int64(hdr.Gid)) {
+		pax["gid"] = strconv.Itoa(hdr.Gid)
+	}
+	if !octal(b[124:136], size) {
+		pax["size"] = strconv.FormatInt(size, 10)
+	}
+	if !octal(b[136:148], hdr.Time) {
+		pax["mtime"] = strconv.FormatInt(hdr.Time, 10)
+	}
+	b[156] = flag
+	copy(b[257:265], "ustar\x0000")
+	if !ascii(b[265:297], hdr.Uname) {
+		pax["uname"] = hdr.Uname
+	}
+	if !ascii(b[297:329], hdr.Gname) {
+		pax["gname"] = hdr.Gname
+	}
+
+	copy(b[148:156], "        ")
+	var sum int64
+	for _, c := range b {
+		sum += int64(c)
+	}
+	copy(b[148:155], fmt.Sprintf("%06o\x00", sum))
+	return b
+}
+
+func pad(b *bytes.Buffer) {
+	b.Write(zeroBlock[:(blockSize-b.Len()%blockSize)%blockSize])
+}
+
+// sparseHeader returns the blocks of a PAX extended header and
+// the header for a GNU 1.0 sparse file followed by the sparse map.
+func sparseHeader(hdr *Header, data int64) []byte {
+	m := new(bytes.Buffer)
+	fmt.Fprintf(m, "%d\n", len(hdr.Sparse))
+	for _, v := range hdr.Sparse {
+		fmt.Fprintf(m, "%d\n%d\n", v.Offset, v.Length)
+	}
+	pad(m)
+
+	pax := map[string]string{
+		"GNU.sparse.major":    "1",
+		"GNU.sparse.minor":    "0",
+		"GNU.sparse.name":     hdr.Name,
+		"GNU.sparse.realsize": strconv.FormatInt(hdr.Size, 10),
+	}
+
+	dir, file := path.Split(hdr.Name)
+	h := *hdr
+	h.Name = path.Join(dir, "GNUSparseFile.0", file)
+	blk := ustarBlock(&h, '0', int64(m.Len())+data, pax)
+
+	p := new(bytes.Buffer)
+	for _, k := range []string{
+		"GNU.sparse.major",
+		"GNU.sparse.minor",
+		"GNU.sparse.name",
+		"GNU.sparse.realsize",
+		"uid", "gid", "size", "mtime", "uname", "gname",
+	} {
+		if v, ok := pax[k]; ok {
+			p.WriteString(paxRecord(k, v))
+		}
+	}
+	x := make([]string, 0, len(hdr.Xattrs))
+	for k := range hdr.Xattrs {
+		x = append(x, k)
+	}
+	sort.Strings(x)
+	for _, k := range x {
+		p.WriteString(paxRecord(xattrPrefix+k, hdr.Xattrs[k]))
+	}
+
+	ph := Header{Name: path.Join(dir, "PaxHeaders.0", file), Mode: 0644}
+	r := bytes.NewBuffer(ustarBlock(&ph, 'x', int64(p.Len()), pax))
+	r.Write(p.Bytes())
+	pad(r)
+	r.Write(blk)
+	r.Write(m.Bytes())
+	return r.Bytes()
+}
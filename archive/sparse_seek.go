@@ -0,0 +1,47 @@
+//go:build linux || freebsd
+// +build linux freebsd
+
+package archive
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// dataRegions finds the data regions of file with SEEK_DATA and SEEK_HOLE.
+func dataRegions(file *os.File, size int64) ([]SparseEntry, error) {
+	var (
+		r   = make([]SparseEntry, 0)
+		off int64
+	)
+
+	for off < size {
+		d, err := file.Seek(off, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			break
+		}
+		if err != nil {
+			// unsupported by the filesystem
+			return nil, nil
+		}
+		h, err := file.Seek(d, seekHole)
+		if err != nil {
+			return nil, nil
+		}
+		if h > size {
+			h = size
+		}
+		r = append(r, SparseEntry{d, h - d})
+		off = h
+	}
+
+	_, err := file.Seek(0, io.SeekStart)
+	return r, err
+}
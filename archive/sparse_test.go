@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/tlahdekorpi/archivegen/config"
+	"github.com/tlahdekorpi/archivegen/cpio"
+)
+
+func sparseFile(t *testing.T, dir string) (string, []byte) {
+	const size = 1 << 20
+
+	want := make([]byte, size)
+	copy(want[4096:], "data1")
+	copy(want[size/2:], "data2")
+
+	file := path.Join(dir, "sparse")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []int64{4096, size / 2} {
+		if _, err := f.WriteAt(want[v:v+5], v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return file, want
+}
+
+func TestSparse(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	file, want := sparseFile(t, tmp)
+
+	for _, format := range []string{"tar", "tar-pax", "tar-gnu", "cpio"} {
+		b := new(bytes.Buffer)
+		w := NewWriter(format, b)
+		if err := writeFile(w, config.Entry{
+			Src: file, Dst: "a/b", Mode: 0644, User: 1, Group: 2,
+		}); err != nil {
+			t.Fatal(format, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(format, err)
+		}
+
+		var (
+			r    io.Reader
+			name string
+		)
+		if format == "cpio" {
+			cr := cpio.NewReader(b)
+			hdr, err := cr.Next()
+			if err != nil {
+				t.Fatal(format, err)
+			}
+			r, name = cr, hdr.Name
+		} else {
+			if n := int64(b.Len()); format != "tar-gnu" && n > 1<<14 {
+				t.Errorf("%s: archive not sparse, size %d", format, n)
+			}
+			tr := tar.NewReader(b)
+			hdr, err := tr.Next()
+			if err != nil {
+				t.Fatal(format, err)
+			}
+			if hdr.Uid != 1 || hdr.Gid != 2 || hdr.Mode != 0644 {
+				t.Errorf("%s: header %+v", format, hdr)
+			}
+			r, name = tr, hdr.Name
+		}
+
+		if name != "a/b" {
+			t.Errorf("%s: name %q", format, name)
+		}
+
+		have, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(format, err)
+		}
+		if !bytes.Equal(have, want) {
+			t.Errorf("%s: contents differ", format)
+		}
+	}
+}
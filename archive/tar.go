@@ -1,6 +1,8 @@
 package archive
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"os"
 	"time"
@@ -8,26 +10,134 @@ import (
 	"archive/tar"
 )
 
+// PAX record prefix of extended attributes.
+const xattrPrefix = "SCHILY.xattr."
+
+var tarFormats = map[string]tar.Format{
+	"tar":       tar.FormatUnknown,
+	"tar-ustar": tar.FormatUSTAR,
+	"tar-pax":   tar.FormatPAX,
+	"tar-gnu":   tar.FormatGNU,
+}
+
 type tarWriter struct {
-	tw *tar.Writer
+	w      io.Writer
+	tw     *tar.Writer
+	format tar.Format
+	names  *Names
+	sparse bool
+}
+
+func newTarWriter(w io.Writer, format tar.Format) *tarWriter {
+	return &tarWriter{
+		w:      w,
+		tw:     tar.NewWriter(w),
+		format: format,
+		sparse: true,
+	}
 }
 
 func (w *tarWriter) Close() error {
 	return w.tw.Close()
 }
 
-func (w *tarWriter) Write(b []byte) (int, error) {
-	return w.tw.Write(b)
+func (w *tarWriter) SetNames(n *Names) {
+	w.names = n
 }
 
-func (w *tarWriter) WriteFile(file *os.File, hdr *Header) error {
-	if err := w.WriteHeader(hdr); err != nil {
+func (w *tarWriter) WriteEntry(hdr *Header, r io.Reader) error {
+	if hdr.Type == TypeSocket {
+		return fmt.Errorf("%s: tar: sockets are not supported", hdr.Name)
+	}
+	if f, ok := r.(*os.File); ok && hdr.Type == TypeRegular {
+		return w.writeFile(f, hdr)
+	}
+	if err := w.writeHeader(hdr); err != nil {
 		return err
 	}
+	if r == nil {
+		return nil
+	}
+	_, err := io.Copy(w.tw, r)
+	return err
+}
+
+func (w *tarWriter) writeFile(file *os.File, hdr *Header) error {
+	if hdr.Sparse != nil && w.sparse {
+		switch w.format {
+		case tar.FormatUnknown, tar.FormatPAX:
+			return w.writeSparse(file, hdr)
+		}
+	}
+	if _, ok := w.w.(*Output); ok && hdr.Sparse == nil {
+		return w.writeRaw(file, hdr)
+	}
+	if err := w.writeHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Sparse != nil {
+		return copySparse(w.tw, file, hdr.Sparse, hdr.Size)
+	}
 	_, err := io.Copy(w.tw, file)
 	return err
 }
 
+// writeRaw encodes the header with a separate tar.Writer and copies
+// the file contents directly to the output.
+func (w *tarWriter) writeRaw(file *os.File, hdr *Header) error {
+	// pad the previous entry
+	if err := w.tw.Flush(); err != nil {
+		return err
+	}
+
+	b := new(bytes.Buffer)
+	if err := tar.NewWriter(b).WriteHeader(w.header(hdr)); err != nil {
+		return fmt.Errorf("%s: %v", hdr.Name, err)
+	}
+	if _, err := w.w.Write(b.Bytes()); err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(w.w, file, hdr.Size); err != nil {
+		return err
+	}
+	return zeros(w.w, (blockSize-hdr.Size%blockSize)%blockSize)
+}
+
+// writeSparse writes a PAX GNU 1.0 sparse file, archive/tar
+// does not support writing sparse files.
+func (w *tarWriter) writeSparse(file *os.File, hdr *Header) error {
+	// pad the previous entry
+	if err := w.tw.Flush(); err != nil {
+		return err
+	}
+
+	t := w.header(hdr)
+	h := *hdr
+	h.Uname, h.Gname = t.Uname, t.Gname
+
+	var n int64
+	for _, v := range hdr.Sparse {
+		n += v.Length
+	}
+
+	b := sparseHeader(&h, n)
+	if _, err := w.w.Write(b); err != nil {
+		return err
+	}
+
+	for _, v := range hdr.Sparse {
+		if _, err := file.Seek(v.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w.w, file, v.Length); err != nil {
+			return err
+		}
+	}
+
+	return zeros(w.w, (blockSize-n%blockSize)%blockSize)
+}
+
 func tarType(t FileType) byte {
 	switch t {
 	case TypeDir:
@@ -42,44 +152,57 @@ func tarType(t FileType) byte {
 		return tar.TypeReg
 	case TypeSymlink:
 		return tar.TypeSymlink
+	case TypeLink:
+		return tar.TypeLink
 	}
 	panic("type")
 }
 
-func tarHeader(a *Header) *tar.Header {
+func (w *tarWriter) header(a *Header) *tar.Header {
 	r := &tar.Header{
 		Name:     a.Name,
+		Linkname: a.Linkname,
 		Uid:      a.Uid,
 		Gid:      a.Gid,
+		Uname:    a.Uname,
+		Gname:    a.Gname,
 		Size:     a.Size,
 		Mode:     a.Mode,
 		Typeflag: tarType(a.Type),
+		Devmajor: a.Devmajor,
+		Devminor: a.Devminor,
+		Format:   w.format,
+	}
+	switch a.Type {
+	case TypeDir:
+		r.Name += "/"
+		r.Size = 0
+	case TypeSymlink, TypeLink:
+		r.Size = 0
 	}
 	if a.Time > 0 {
 		r.ModTime = time.Unix(a.Time, 0)
 	}
-	return r
-}
-
-func (w *tarWriter) WriteHeader(hdr *Header) error {
-	if hdr.Type == TypeDir {
-		hdr.Name += "/"
+	for k, v := range a.Xattrs {
+		if r.PAXRecords == nil {
+			r.PAXRecords = make(map[string]string)
+		}
+		r.PAXRecords[xattrPrefix+k] = v
 	}
-	return w.tw.WriteHeader(tarHeader(hdr))
+	if w.names != nil {
+		if r.Uname == "" {
+			r.Uname = w.names.User[r.Uid]
+		}
+		if r.Gname == "" {
+			r.Gname = w.names.Group[r.Gid]
+		}
+	}
+	return r
 }
 
-func (w *tarWriter) Symlink(src, dst string, uid, gid, mode int) error {
-	hdr := &tar.Header{
-		Name:     dst,
-		Linkname: src,
-		Size:     0,
-		Mode:     int64(mode),
-		Uid:      uid,
-		Gid:      gid,
-		Typeflag: tar.TypeSymlink,
-	}
-	if err := w.tw.WriteHeader(hdr); err != nil {
-		return err
+func (w *tarWriter) writeHeader(hdr *Header) error {
+	if err := w.tw.WriteHeader(w.header(hdr)); err != nil {
+		return fmt.Errorf("%s: %v", hdr.Name, err)
 	}
 	return nil
 }
@@ -1,6 +1,7 @@
 package archive
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
 	"os"
@@ -8,8 +9,20 @@ import (
 	"github.com/tlahdekorpi/archivegen/config"
 )
 
-func writeFile(w Writer, src, dst string, mode, uid, gid int, time int64) error {
-	f, err := os.Open(src)
+func header(e config.Entry, t FileType, size int64) *Header {
+	return &Header{
+		Name: e.Dst,
+		Size: size,
+		Mode: int64(e.Mode),
+		Uid:  e.User,
+		Gid:  e.Group,
+		Type: t,
+		Time: e.Time,
+	}
+}
+
+func writeFile(w Writer, e config.Entry) error {
+	f, err := os.Open(e.Src)
 	if err != nil {
 		return err
 	}
@@ -20,56 +33,70 @@ func writeFile(w Writer, src, dst string, mode, uid, gid int, time int64) error
 		return err
 	}
 
-	return w.WriteFile(f,
-		&Header{
-			Name: dst,
-			Size: int64(fs.Size()),
-			Mode: int64(mode),
-			Uid:  uid,
-			Gid:  gid,
-			Type: TypeRegular,
-			Time: time,
-		},
-	)
+	return writeOpen(w, f, fs, e)
+}
+
+func writeOpen(w Writer, f *os.File, fs os.FileInfo, e config.Entry) error {
+	sp, err := sparseRegions(f, fs.Size())
+	if err != nil {
+		return err
+	}
+
+	hdr := header(e, TypeRegular, fs.Size())
+	hdr.Sparse = sp
+	return w.WriteEntry(hdr, f)
 }
 
 func writeDir(w Writer, dst string, mode, uid, gid int) error {
-	return w.WriteHeader(&Header{
+	return w.WriteEntry(&Header{
 		Name: dst,
 		Size: 0,
 		Mode: int64(mode),
 		Uid:  uid,
 		Gid:  gid,
 		Type: TypeDir,
-	})
+	}, nil)
 }
 
-func createFile(w Writer, dst string, mode, uid, gid int, data []byte, time int64) error {
-	if err := w.WriteHeader(&Header{
-		Name: dst,
-		Size: int64(len(data)),
-		Mode: int64(mode),
-		Uid:  uid,
-		Gid:  gid,
-		Type: TypeRegular,
-		Time: time,
-	}); err != nil {
-		return err
-	}
-	_, err := w.Write(data)
-	return err
+func createFile(w Writer, e config.Entry) error {
+	return w.WriteEntry(
+		header(e, TypeRegular, int64(len(e.Data))),
+		bytes.NewReader(e.Data),
+	)
+}
+
+func writeLink(w Writer, e config.Entry, t FileType) error {
+	hdr := header(e, t, 0)
+	hdr.Linkname = e.Src
+	return w.WriteEntry(hdr, nil)
 }
 
 func Write(e config.Entry, w Writer) error {
+	m := new(members)
+	defer m.close()
+	return writeEntry(e, w, m)
+}
+
+func writeEntry(e config.Entry, w Writer, m *members) error {
 	switch e.Type {
 	case config.TypeRegular:
-		return writeFile(w, e.Src, e.Dst, e.Mode, e.User, e.Group, e.Time)
+		return writeFile(w, e)
 
 	case config.TypeDirectory:
 		return writeDir(w, e.Src, e.Mode, e.User, e.Group)
 
 	case config.TypeSymlink:
-		return w.Symlink(e.Src, e.Dst, e.User, e.Group, e.Mode)
+		return writeLink(w, e, TypeSymlink)
+
+	case typeHardlink:
+		return writeLink(w, e, TypeLink)
+
+	case config.TypeArchive:
+		r, n, err := m.open(e)
+		if err != nil {
+			return err
+		}
+		return w.WriteEntry(header(e, TypeRegular, n), r)
 
 	case config.TypeBase64:
 		d := make([]byte, base64.StdEncoding.DecodedLen(len(e.Data)))
@@ -80,7 +107,7 @@ func Write(e config.Entry, w Writer) error {
 		e.Data = d[:n]
 		fallthrough
 	case config.TypeCreate, config.TypeCreateNoEndl:
-		return createFile(w, e.Dst, e.Mode, e.User, e.Group, e.Data, e.Time)
+		return createFile(w, e)
 	}
 
 	return fmt.Errorf("tree: write error: unknown type %q", e)
@@ -1,21 +1,25 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"text/tabwriter"
+	"time"
 	"unsafe"
 
 	"github.com/tlahdekorpi/archivegen/archive"
 	"github.com/tlahdekorpi/archivegen/config"
 	"github.com/tlahdekorpi/archivegen/elf"
+	"github.com/tlahdekorpi/archivegen/progress"
 )
 
 var buildversion string = "v0"
@@ -26,55 +30,200 @@ func isterm(file *os.File) bool {
 	return err == 0
 }
 
-func open(file string) *os.File {
-	r, err := os.OpenFile(
-		file,
-		os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
-		0644,
-	)
-	if err != nil {
-		log.Fatal(err)
+func open(file string, append bool) (*os.File, error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if append {
+		flag = os.O_RDWR | os.O_CREATE
 	}
-	return r
+	return os.OpenFile(file, flag, 0644)
 }
 
-func loadTree(c *config.Config, files []string, stdin bool) (*archive.Node, error) {
-	var m1, m2 *config.Map
-	var err error
-
-	if stdin {
-		m1, err = c.FromReader(os.Stdin)
+// textProgress returns a Func writing events to w as a status line,
+// at most every 100ms, and a function writing the last event and
+// ending the line, which does nothing without a line.
+func textProgress(w io.Writer) (progress.Func, func()) {
+	var (
+		mu      sync.Mutex
+		last    time.Time
+		pending *progress.Event
+		line    bool
+	)
+	print := func(e progress.Event) {
+		line = true
+		if e.Stage == progress.Write {
+			fmt.Fprintf(w, "\r\033[K%s: %d entries, %d bytes", e.Stage, e.Entries, e.Bytes)
+		} else {
+			fmt.Fprintf(w, "\r\033[K%s: %d entries", e.Stage, e.Entries)
+		}
 	}
-	if err != nil {
-		return nil, fmt.Errorf("stdin: %v", err)
+	fn := func(e progress.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(last) < 100*time.Millisecond {
+			pending = &e
+			return
+		}
+		last = time.Now()
+		pending = nil
+		print(e)
+	}
+	return fn, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if pending != nil {
+			print(*pending)
+			pending = nil
+		}
+		if line {
+			fmt.Fprintln(w)
+			line = false
+		}
 	}
+}
 
-	m2, err = c.FromFiles(files...)
-	if err != nil {
-		return nil, err
+// progressContext returns a context reporting progress in mode and
+// a function to call when done.
+func progressContext(ctx context.Context, mode string) (context.Context, func()) {
+	switch mode {
+	case "":
+		return ctx, func() {}
+	case "text":
+		fn, done := textProgress(os.Stderr)
+		return progress.WithFunc(ctx, fn), done
+	case "json":
+		return progress.WithFunc(ctx, progress.JSON(os.Stderr)), func() {}
 	}
+	log.Fatalf("progress: unknown mode: %s", mode)
+	return nil, nil
+}
+
+// interrupt returns a context canceled on the first interrupt,
+// a second one exits.
+func interrupt() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		cancel()
+		<-c
+		os.Exit(1)
+	}()
+	return ctx
+}
 
-	if m1 != nil {
-		err = m1.Merge(m2)
+// loadTree returns the tree of the configuration, with -k it is
+// returned together with the errors of the failing entries.
+func loadTree(ctx context.Context, c *config.Config, files []string, stdin bool) (*archive.Node, error) {
+	var (
+		m   *config.Map
+		err error
+	)
+	if stdin {
+		if m, err = c.FromReader(ctx, os.Stdin); err != nil {
+			err = fmt.Errorf("stdin: %v", err)
+		}
 	} else {
-		m1 = m2
+		m, err = c.FromFiles(ctx, files...)
 	}
-	if err != nil {
+	if m == nil {
 		return nil, err
 	}
 
-	t := archive.Render(m1)
-	if len(t.Map) == 0 {
+	// with -lint the configuration is checked and not the archive,
+	// files of only masks and variables are valid includes.
+	t := archive.Render(m)
+	if len(t.Map) == 0 && !c.Opt.Lint {
+		if err != nil {
+			return nil, err
+		}
 		return nil, fmt.Errorf("empty archive")
 	}
 
-	return t, nil
+	return t, err
+}
+
+// writeTree writes t to w, with keep it continues past entries that
+// cannot be read.
+func writeTree(ctx context.Context, t *archive.Node, w archive.Writer, readahead int, keep bool) error {
+	if keep {
+		return t.WriteAll(ctx, "", w, readahead)
+	}
+	return t.WriteAhead(ctx, "", w, readahead)
+}
+
+// writeDir writes t into the directory of the root option, with keep
+// write errors are logged and the status is set instead.
+func writeDir(ctx context.Context, t *archive.Node, opts archive.Options, readahead int, keep bool) (status int, err error) {
+	if opts["root"] == "" {
+		return 0, fmt.Errorf("dir: -out is required")
+	}
+	w, err := archive.New("dir", nil, opts)
+	if err != nil {
+		return 0, err
+	}
+	werr := writeTree(ctx, t, w, readahead, keep)
+
+	// closing restores the directory modes and writes the ownership,
+	// also after an error.
+	cerr := w.Close()
+	if werr != nil {
+		if !keep {
+			if cerr != nil {
+				log.Println(cerr)
+			}
+			return 0, fmt.Errorf("write: %v", werr)
+		}
+		log.Println("write:", werr)
+		status = 1
+	}
+	return status, cerr
 }
 
-func printTree(t *archive.Node, b64 bool) {
+func printTree(t *archive.Node, b64 bool) error {
 	tw := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
-	t.Print("", tw, os.Stdout, b64)
+	err := t.Print("", tw, os.Stdout, b64)
 	tw.Flush()
+	if err != nil {
+		return fmt.Errorf("print: %v", err)
+	}
+	return nil
+}
+
+// lintConfig prints the lint findings of c, the exit status is non-zero
+// on findings or when reading failed.
+func lintConfig(c *config.Config, err error) (status int) {
+	if err != nil {
+		log.Print(err)
+		status = 1
+	}
+	for _, v := range c.Lint() {
+		fmt.Println(v)
+		status = 1
+	}
+	return status
+}
+
+// checkTree prints the differences of the archive file to t, which
+// are an error.
+func checkTree(t *archive.Node, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := t.Check(f)
+	if err != nil {
+		return fmt.Errorf("check: %v", err)
+	}
+	for _, v := range r {
+		fmt.Println(v)
+	}
+	if len(r) > 0 {
+		return fmt.Errorf("check: %s: %d differences", file, len(r))
+	}
+	return nil
 }
 
 type varValue []string
@@ -91,12 +240,18 @@ func (v *varValue) Set(val string) error {
 }
 
 type opts struct {
+	Append        bool   `desc:"Append entries to an existing archive in -out"`
 	ArchiveFormat bool   `desc:"Archive configuration file format" flag:"format"`
 	Base64        bool   `desc:"Base64 encode all create types" flag:"b64"`
+	Check         string `desc:"Verify an existing archive against the configuration"`
 	Chdir         string `desc:"Change directory before doing anything" flag:"C"`
-	Format        string `desc:"Output archive format" flag:"fmt"`
+	Dedup         bool   `desc:"Write files with identical contents and metadata as hardlinks"`
+	Format        string `desc:"Output archive format: tar, tar-ustar, tar-pax, tar-gnu, cpio or dir" flag:"fmt"`
+	Names         bool   `desc:"Record user and group names from rootfs etc/passwd and etc/group"`
 	Out           string `desc:"Output destination"`
 	Print         bool   `desc:"Print the resolved tree in archivegen format"`
+	Progress      string `desc:"Report progress to stderr: text or json"`
+	Readahead     int    `desc:"Number of workers opening and reading files ahead of the writer"`
 	Rootfs        string `desc:"Alternate root for relative and ELF types"`
 	Stdout        bool   `desc:"Write archive to stdout"`
 	Version       bool   `desc:"Version information"`
@@ -108,33 +263,48 @@ func main() {
 	log.SetFlags(0)
 	log.SetPrefix("archivegen: ")
 
+	if len(os.Args) > 1 && os.Args[1] == "fmt" {
+		os.Exit(formatMain(os.Args[2:]))
+	}
+
 	opt := opts{
 		Format: "tar",
 		Ldconf: "/etc/ld.so.conf",
 		Size:   1 << 22,
+
+		Readahead: runtime.NumCPU(),
 	}
 	buildflags(&opt, "")
 
+	var copt config.Options
+
 	// Resolving all symlinks is required when symlinks inside the prefix
 	// lead to outside of the prefix.
-	config.Opt.Glob.Expand = true
-	config.Opt.File.Expand = true
+	copt.Glob.Expand = true
+	copt.File.Expand = true
 
 	// ELFs depending on libraries from rpath/runpath $ORIGIN will
 	// fail to resolve since the symlink is used as $ORIGIN.
-	config.Opt.ELF.Expand = true
+	copt.ELF.Expand = true
 
-	config.Opt.ELF.NumGoroutine = runtime.NumCPU() * 2
-	buildflags(&config.Opt, "")
+	copt.ELF.NumGoroutine = runtime.NumCPU() * 2
+	buildflags(&copt, "")
 
 	var varX varValue
 	flag.Var(&varX, "X", "Variable\n"+
 		"e.g. '-X foo=bar -X a=b'",
 	)
 
+	var varO varValue
+	flag.Var(&varO, "O", "Output format option\n"+
+		"e.g. '-O sparse=false'",
+	)
+
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "%s %s\n", "archivegen", "[OPTIONS...] [FILES...]")
+		fmt.Fprintf(os.Stderr, "%s %s\n", "archivegen fmt", "[OPTIONS...] [FILES...]")
 		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nFormats: %s\n", strings.Join(archive.Formats(), " "))
 	}
 	flag.Parse()
 
@@ -142,13 +312,14 @@ func main() {
 		Resolver: elf.NewResolver(opt.Rootfs),
 		Prefix:   opt.Rootfs,
 		Vars:     []string(varX),
+		Opt:      copt,
 	}
 	if err := c.Resolver.ReadConfig(opt.Ldconf); err != nil {
 		log.Fatalln("ld.so.conf:", err)
 	}
 
-	if config.Opt.Path == nil {
-		config.Opt.Path = strings.Split(os.Getenv("PATH"), ":")
+	if c.Opt.Path == nil {
+		c.Opt.Path = strings.Split(os.Getenv("PATH"), ":")
 	}
 
 	if opt.Chdir != "" {
@@ -172,48 +343,127 @@ func main() {
 		log.Fatal("not enough arguments")
 	}
 
-	root, err := loadTree(c, flag.Args(), !stdin && flag.NArg() == 0)
+	ctx, done := progressContext(interrupt(), opt.Progress)
+	defer done()
+
+	// the progress line is ended before exiting.
+	exit := func(status int) {
+		done()
+		os.Exit(status)
+	}
+	fatal := func(v ...interface{}) {
+		done()
+		log.Fatal(v...)
+	}
+
+	// with -k errors are reported as they occur and the
+	// exit status is set at the end.
+	var status int
+
+	root, err := loadTree(ctx, c, flag.Args(), !stdin && flag.NArg() == 0)
+	if c.Opt.Lint || opt.Print || opt.Check != "" {
+		// the rest is written to stdout.
+		done()
+	}
+	if c.Opt.Lint {
+		exit(lintConfig(c, err))
+	}
 	if err != nil {
-		log.Fatal(err)
+		if root == nil || !c.Opt.KeepGoing {
+			fatal(err)
+		}
+		log.Print(err)
+		status = 1
 	}
 
 	if opt.Print {
-		printTree(root, opt.Base64)
-		os.Exit(0)
+		if err := printTree(root, opt.Base64); err != nil {
+			fatal(err)
+		}
+		exit(status)
+	}
+
+	if opt.Check != "" {
+		if err := checkTree(root, opt.Check); err != nil {
+			fatal(err)
+		}
+		exit(status)
+	}
+
+	if opt.Dedup {
+		n, err := root.Dedup()
+		if err != nil {
+			fatal("dedup: ", err)
+		}
+		log.Printf("dedup: %d bytes saved", n)
+	}
+
+	fo := make(archive.Options)
+	for i := 0; i < len(varO); i += 2 {
+		fo[varO[i]] = varO[i+1]
+	}
+
+	if opt.Format == "dir" {
+		if opt.Out != "" {
+			fo["root"] = opt.Out
+		}
+		n, err := writeDir(ctx, root, fo, opt.Readahead, c.Opt.KeepGoing)
+		if err != nil {
+			fatal(err)
+		}
+		if n != 0 {
+			status = 1
+		}
+		exit(status)
 	}
 
 	var out *os.File = os.Stdout
 	if opt.Out != "" {
-		out = open(opt.Out)
+		if out, err = open(opt.Out, opt.Append); err != nil {
+			fatal(err)
+		}
+	} else if opt.Append {
+		fatal("append: -out is required")
 	} else if stdout && !opt.Stdout {
-		log.Fatal("stdout is terminal, use -stdout")
+		fatal("stdout is terminal, use -stdout")
 	}
 
-	var (
-		wr  io.Writer = out
-		buf *bufio.Writer
-	)
-	if opt.Size > 0 {
-		buf = bufio.NewWriterSize(out, opt.Size)
-		wr = buf
+	wr := archive.NewOutput(out, opt.Size)
+
+	var in archive.Writer
+	if opt.Append {
+		in, err = archive.Append(opt.Format, out, wr, fo)
 	} else {
-		buf = new(bufio.Writer)
+		in, err = archive.New(opt.Format, wr, fo)
+	}
+	if err != nil {
+		fatal(err)
 	}
 
-	in := archive.NewWriter(opt.Format, wr)
-	if in == nil {
-		log.Fatalln("unknown format:", opt.Format)
+	if n, ok := in.(archive.Namer); ok && opt.Names {
+		names, err := archive.ReadNames(opt.Rootfs)
+		if err != nil {
+			fatal("names: ", err)
+		}
+		n.SetNames(names)
 	}
 
-	if err := root.Write("", in); err != nil {
-		log.Fatalln("write:", err)
+	if err := writeTree(ctx, root, in, opt.Readahead, c.Opt.KeepGoing); err != nil {
+		if !c.Opt.KeepGoing {
+			fatal("write: ", err)
+		}
+		log.Println("write:", err)
+		status = 1
 	}
 
+	done()
+
 	for k, v := range []func() error{
-		in.Close, buf.Flush, out.Close,
+		in.Close, wr.Flush, out.Close,
 	} {
 		if err := v(); err != nil {
-			log.Fatalf("error(%d): %v", k, err)
+			fatal(fmt.Sprintf("error(%d): %v", k, err))
 		}
 	}
+	exit(status)
 }
@@ -0,0 +1,359 @@
+package config
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tlahdekorpi/archivegen/cpio"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ArchiveReader reads the members of a tar or cpio archive, optionally
+// gzip compressed. Hardlinks are reported as regular files with Src set
+// to the link target and without contents, members of other types are
+// skipped.
+type ArchiveReader struct {
+	r    io.Reader
+	z    *gzip.Reader
+	cur  io.Reader
+	i    int
+	next func() (Entry, int64, error)
+}
+
+// NewArchiveReader returns a reader of the archive read from r.
+func NewArchiveReader(r io.Reader) (*ArchiveReader, error) {
+	a := &ArchiveReader{i: -1}
+	b := bufio.NewReader(r)
+
+	if m, _ := b.Peek(2); string(m) == string(gzipMagic) {
+		z, err := gzip.NewReader(b)
+		if err != nil {
+			return nil, err
+		}
+		a.z = z
+		b = bufio.NewReader(z)
+	}
+
+	m, _ := b.Peek(6)
+	switch string(m) {
+	case "070701", "070702":
+		cr := cpio.NewReader(b)
+		a.r, a.next = cr, cpioNext(cr, &a.i)
+	default:
+		tr := tar.NewReader(b)
+		a.r, a.next = tr, tarNext(tr, &a.i)
+	}
+	return a, nil
+}
+
+// Next advances to the next member, returning it with the size of its
+// contents. io.EOF is returned at the end of the archive.
+func (a *ArchiveReader) Next() (Entry, int64, error) {
+	for {
+		e, n, err := a.next()
+		if err != nil {
+			return e, n, err
+		}
+		a.cur = io.LimitReader(a.r, n)
+		if e.Type != "" && e.Dst != "." {
+			return e, n, nil
+		}
+	}
+}
+
+// Index returns the position of the current member in the archive,
+// members of every type are counted. Names can repeat in an archive,
+// the index identifies the member carrying the contents of a file.
+func (a *ArchiveReader) Index() int {
+	return a.i
+}
+
+// Read reads the contents of the current member.
+func (a *ArchiveReader) Read(b []byte) (int, error) {
+	if a.cur == nil {
+		return 0, io.EOF
+	}
+	return a.cur.Read(b)
+}
+
+// Close releases the decompressor, it does not close the underlying
+// reader.
+func (a *ArchiveReader) Close() error {
+	if a.z != nil {
+		return a.z.Close()
+	}
+	return nil
+}
+
+// ReadArchive calls fn for every member of the archive read from r,
+// see ArchiveReader.
+func ReadArchive(r io.Reader, fn func(Entry, io.Reader) error) error {
+	a, err := NewArchiveReader(r)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	for {
+		e, _, err := a.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(e, a); err != nil {
+			return err
+		}
+	}
+}
+
+// tarNext returns the members of tr, members of unsupported types are
+// returned without a type. i is set to the index of the member.
+func tarNext(tr *tar.Reader, i *int) func() (Entry, int64, error) {
+	return func() (Entry, int64, error) {
+		hdr, err := tr.Next()
+		if err != nil {
+			return Entry{}, 0, err
+		}
+		*i++
+
+		e := Entry{
+			Dst:   clean(hdr.Name),
+			User:  hdr.Uid,
+			Group: hdr.Gid,
+			Mode:  int(hdr.Mode & 07777),
+			Time:  hdr.ModTime.Unix(),
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			e.Type = TypeRegular
+		case tar.TypeLink:
+			e.Type = TypeRegular
+			e.Src = clean(hdr.Linkname)
+		case tar.TypeSymlink:
+			e.Type = TypeSymlink
+			e.Src = hdr.Linkname
+		case tar.TypeDir:
+			e.Type = TypeDirectory
+		}
+		return e, hdr.Size, nil
+	}
+}
+
+// cpioNext is tarNext for cpio archives. Hardlinks share an inode, the
+// data is stored on the first link or, as GNU cpio does, on the last
+// one. Links without data are held back until the link carrying the
+// data is read and returned after it.
+func cpioNext(cr *cpio.Reader, i *int) func() (Entry, int64, error) {
+	type held struct {
+		e Entry
+		i int
+	}
+	var (
+		links = make(map[int64]string) // link carrying the data.
+		wait  = make(map[int64][]held) // links read before it.
+		order []int64
+		queue []held
+		n     = -1
+		eof   bool
+	)
+	return func() (Entry, int64, error) {
+		for len(queue) == 0 {
+			if eof {
+				return Entry{}, 0, io.EOF
+			}
+			hdr, err := cr.Next()
+			if err == io.EOF {
+				eof = true
+				// links of inodes without data are empty files,
+				// the first is returned as the file.
+				for _, v := range order {
+					l, ok := wait[v]
+					if !ok {
+						continue
+					}
+					for k := range l[1:] {
+						l[k+1].e.Src = l[0].e.Dst
+					}
+					queue = append(queue, l...)
+				}
+				order, wait = nil, nil
+				continue
+			}
+			if err != nil {
+				return Entry{}, 0, err
+			}
+			n++
+			*i = n
+
+			e := Entry{
+				Dst:   clean(hdr.Name),
+				User:  hdr.Uid,
+				Group: hdr.Gid,
+				Mode:  hdr.Mode,
+				Time:  hdr.Mtime,
+			}
+
+			switch hdr.Type {
+			case cpio.TypeRegular:
+				e.Type = TypeRegular
+				if hdr.Links < 2 {
+					break
+				}
+				if v, ok := links[hdr.Inode]; ok {
+					if hdr.Size == 0 {
+						e.Src = v
+					}
+					break
+				}
+				if hdr.Size == 0 {
+					if _, ok := wait[hdr.Inode]; !ok {
+						order = append(order, hdr.Inode)
+					}
+					wait[hdr.Inode] = append(wait[hdr.Inode], held{e, n})
+					continue
+				}
+				links[hdr.Inode] = e.Dst
+				for _, v := range wait[hdr.Inode] {
+					v.e.Src = e.Dst
+					queue = append(queue, v)
+				}
+				delete(wait, hdr.Inode)
+			case cpio.TypeSymlink:
+				l, err := ioutil.ReadAll(cr)
+				if err != nil {
+					return Entry{}, 0, err
+				}
+				e.Type = TypeSymlink
+				e.Src = string(l)
+				return e, 0, nil
+			case cpio.TypeDir:
+				e.Type = TypeDirectory
+			}
+			return e, hdr.Size, nil
+		}
+
+		h := queue[0]
+		queue = queue[1:]
+		*i = h.i
+		return h.e, 0, nil
+	}
+}
+
+// archivePrefix returns the leading directories of p without regexp
+// characters, which are stripped from matching members when dst is set.
+func archivePrefix(p string) string {
+	p = strings.TrimPrefix(p, "^")
+	if i := strings.IndexAny(p, rechars); i >= 0 {
+		p = p[:i]
+	}
+	return p[:strings.LastIndexByte(p, '/')+1]
+}
+
+// addArchive adds the members of an archive, regular files are added as
+// TypeArchive entries with Src set to the archive and Data to the index
+// of the member with the contents, which are read when written.
+func (m *Map) addArchive(e entry, fail bool) error {
+	if len(e) < 2 {
+		return errInvalidEntry
+	}
+
+	var p, dst string
+	if e.isSet(idxDst) {
+		p = e[idxDst]
+	}
+	if e.isSet(idxMode) {
+		dst = clean(unescape(e[idxMode]))
+	}
+
+	r, err := regexp.Compile(p)
+	if err != nil {
+		return err
+	}
+
+	uid, err := e.pUser()
+	if err != nil {
+		return err
+	}
+	gid, err := e.pGroup()
+	if err != nil {
+		return err
+	}
+
+	src := unescape(e[idxSrc])
+	f, err := os.Open(src)
+	if fail && os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p = archivePrefix(p)
+
+	a, err := NewArchiveReader(f)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	// the index of the member with the contents of every regular
+	// file read, a repeated name replaces the earlier member.
+	data := make(map[string]int)
+
+	for {
+		E, _, err := a.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := E.Dst
+		if !r.MatchString(name) {
+			continue
+		}
+
+		if dst != "" {
+			E.Dst = path.Join(dst, strings.TrimPrefix(name, p))
+		}
+
+		switch E.Type {
+		case TypeRegular:
+			i := a.Index()
+			if E.Src != "" {
+				var ok bool
+				if i, ok = data[E.Src]; !ok {
+					return fmt.Errorf("%s: hardlink to excluded member %s", name, E.Src)
+				}
+			}
+			data[name] = i
+			E.Src = src
+			E.Type = TypeArchive
+			E.Data = []byte(strconv.Itoa(i))
+		case TypeDirectory:
+			E.Src = E.Dst
+		}
+
+		// archive times are not preserved, same as with other types.
+		E.Time = 0
+		E.User = idef(uid, uint32(E.User))
+		E.Group = idef(gid, uint32(E.Group))
+
+		m.Add(E)
+	}
+}
@@ -0,0 +1,309 @@
+package config
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tlahdekorpi/archivegen/cpio"
+)
+
+var archiveTestData = []struct {
+	name, link string
+	data       string
+	mode       int
+	t          string
+}{
+	{name: "usr", mode: 0755, t: TypeDirectory},
+	{name: "usr/lib", mode: 0755, t: TypeDirectory},
+	{name: "usr/lib/libfoo.so.1", mode: 0755, data: "foo", t: TypeRegular},
+	{name: "usr/lib/libfoo.so", link: "libfoo.so.1", mode: 0777, t: TypeSymlink},
+	{name: "usr/lib/libbar.a", mode: 0644, data: "bar", t: TypeRegular},
+	{name: "usr/bin/foo", mode: 0755, data: "#!/bin/sh\n", t: TypeRegular},
+}
+
+func testTar(t *testing.T, gz bool) []byte {
+	b := new(bytes.Buffer)
+
+	var z *gzip.Writer
+	tw := tar.NewWriter(b)
+	if gz {
+		z = gzip.NewWriter(b)
+		tw = tar.NewWriter(z)
+	}
+
+	for _, v := range archiveTestData {
+		hdr := &tar.Header{
+			Name:     "./" + v.name,
+			Linkname: v.link,
+			Mode:     int64(v.mode),
+			Size:     int64(len(v.data)),
+			Uid:      1,
+			Gid:      2,
+		}
+		switch v.t {
+		case TypeDirectory:
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		case TypeSymlink:
+			hdr.Typeflag = tar.TypeSymlink
+		default:
+			hdr.Typeflag = tar.TypeReg
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(v.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if z != nil {
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b.Bytes()
+}
+
+func testCpio(t *testing.T) []byte {
+	b := new(bytes.Buffer)
+	cw := cpio.NewWriter(b)
+
+	for _, v := range archiveTestData {
+		hdr := &cpio.Header{
+			Name: v.name,
+			Mode: v.mode,
+			Size: int64(len(v.data)),
+			Uid:  1,
+			Gid:  2,
+		}
+		data := v.data
+		switch v.t {
+		case TypeDirectory:
+			hdr.Type = cpio.TypeDir
+		case TypeSymlink:
+			hdr.Type = cpio.TypeSymlink
+			hdr.Size = int64(len(v.link))
+			data = v.link
+		default:
+			hdr.Type = cpio.TypeRegular
+		}
+		if err := cw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestArchive(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, v := range []struct {
+		name string
+		data []byte
+	}{
+		{"test.tar", testTar(t, false)},
+		{"test.tar.gz", testTar(t, true)},
+		{"test.cpio", testCpio(t)},
+	} {
+		file := path.Join(tmp, v.name)
+		if err := ioutil.WriteFile(file, v.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var c Config
+		m, err := c.FromReader(context.Background(), bytes.NewBufferString(
+			"A "+file+" usr/lib/libfoo opt/lib - 3\n"+
+				"A "+file+" bin/\n"+
+				"?A "+file+".missing\n",
+		))
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+
+		want := []Entry{
+			{Src: file, Dst: "opt/lib/libfoo.so.1", Mode: 0755, User: 1, Group: 3, Type: TypeArchive, Data: []byte("2")},
+			{Src: "libfoo.so.1", Dst: "opt/lib/libfoo.so", Mode: 0777, User: 1, Group: 3, Type: TypeSymlink},
+			{Src: file, Dst: "usr/bin/foo", Mode: 0755, User: 1, Group: 2, Type: TypeArchive, Data: []byte("5")},
+		}
+
+		if a, b := len(m.A), len(want); a != b {
+			t.Fatalf("%s: len %d != %d", v.name, a, b)
+		}
+		for k := range want {
+			if !equal(&m.A[k], &want[k]) {
+				t.Errorf("%s: %d\n%v\n%v", v.name, k, m.A[k], want[k])
+			}
+		}
+	}
+}
+
+func TestArchiveHardlink(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	b := new(bytes.Buffer)
+	tw := tar.NewWriter(b)
+	for _, v := range []*tar.Header{
+		{Name: "a", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		{Name: "b", Typeflag: tar.TypeLink, Mode: 0644, Linkname: "a"},
+		{Name: "c", Typeflag: tar.TypeLink, Mode: 0644, Linkname: "./b"},
+	} {
+		if err := tw.WriteHeader(v); err != nil {
+			t.Fatal(err)
+		}
+		if v.Size > 0 {
+			if _, err := tw.Write([]byte("a")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file := path.Join(tmp, "test.tar")
+	if err := ioutil.WriteFile(file, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var c Config
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString("A "+file+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.A) != 3 {
+		t.Fatalf("len %d != 3", len(m.A))
+	}
+	for _, v := range m.A {
+		if v.Type != TypeArchive || v.Src != file || string(v.Data) != "0" {
+			t.Errorf("%s: %v", v.Dst, v)
+		}
+	}
+
+	// the link target is excluded.
+	_, err = c.FromReader(context.Background(), bytes.NewBufferString("A "+file+" ^[bc]\n"))
+	if err == nil || !strings.Contains(err.Error(), "hardlink to excluded member a") {
+		t.Errorf("error %v", err)
+	}
+}
+
+// TestArchiveCpioHardlink reads hardlinks with the data on the first
+// link and, as written by GNU cpio, on the last one.
+func TestArchiveCpioHardlink(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	b := new(bytes.Buffer)
+	cw := cpio.NewWriter(b)
+	for _, v := range []struct {
+		name  string
+		inode int64
+		links int
+		data  string
+	}{
+		{"first/a", 1, 2, "first"},
+		{"first/b", 1, 2, ""},
+		{"gnu/a", 2, 3, ""},
+		{"gnu/b", 2, 3, ""},
+		{"gnu/c", 2, 3, "gnu"},
+		{"empty/a", 3, 2, ""},
+		{"empty/b", 3, 2, ""},
+	} {
+		if err := cw.WriteHeader(&cpio.Header{
+			Name:  v.name,
+			Mode:  0644,
+			Type:  cpio.TypeRegular,
+			Size:  int64(len(v.data)),
+			Inode: v.inode,
+			Links: v.links,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cw.Write([]byte(v.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file := path.Join(tmp, "test.cpio")
+	if err := ioutil.WriteFile(file, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	have := make(map[string]string)
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := ReadArchive(f, func(e Entry, r io.Reader) error {
+		d, err := ioutil.ReadAll(r)
+		have[e.Dst] = e.Src + ":" + string(d)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"first/a": ":first",
+		"first/b": "first/a:",
+		"gnu/a":   "gnu/c:",
+		"gnu/b":   "gnu/c:",
+		"gnu/c":   ":gnu",
+		"empty/a": ":",
+		"empty/b": "empty/a:",
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	var c Config
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString("A "+file+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]string{
+		"first/a": "0", "first/b": "0",
+		"gnu/a": "4", "gnu/b": "4", "gnu/c": "4",
+		"empty/a": "5", "empty/b": "5",
+	}
+	if len(m.A) != len(data) {
+		t.Fatalf("entries: %v", m.A)
+	}
+	for _, v := range m.A {
+		if v.Type != TypeArchive || string(v.Data) != data[v.Dst] {
+			t.Errorf("%s: %v", v.Dst, v)
+		}
+	}
+}
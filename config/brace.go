@@ -0,0 +1,213 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// multi returns the brace expansion of s. Groups {a,b} and ranges
+// {1..8}, {a..f} and {1..8..2} can be nested and repeated, commas and
+// braces escaped with a backslash are literal. With glob groups start
+// with \{ as braces in regular expressions are left as is.
+func multi(s string, glob bool) []string {
+	i, n := opening(s, glob)
+	if i < 0 {
+		return []string{unbrace(s, glob)}
+	}
+	j := closing(s, i+n, glob)
+	if j < 0 {
+		return []string{unbrace(s, glob)}
+	}
+
+	a, body, tail := unbrace(s[:i], glob), s[i+n:j], multi(s[j+1:], glob)
+
+	alts := alternatives(body, glob)
+	if alts == nil {
+		alts = ranges(body)
+	}
+
+	var r []string
+	if alts == nil {
+		// not a group, left as is.
+		for _, b := range multi(body, glob) {
+			for _, t := range tail {
+				r = append(r, a+s[i:i+n]+b+"}"+t)
+			}
+		}
+		return r
+	}
+
+	for _, v := range alts {
+		for _, b := range multi(v, glob) {
+			for _, t := range tail {
+				r = append(r, a+b+t)
+			}
+		}
+	}
+	return r
+}
+
+// opening returns the index and length of the first group opening in s.
+func opening(s string, glob bool) (int, int) {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case glob && strings.HasPrefix(s[i:], `\{`):
+			return i, 2
+		case s[i] == '\\':
+			i++
+		case !glob && s[i] == '{':
+			return i, 1
+		}
+	}
+	return -1, 0
+}
+
+// closing returns the index of the } closing a group starting at i.
+func closing(s string, i int, glob bool) int {
+	var d int
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if glob && strings.HasPrefix(s[i:], `\{`) {
+				d++
+			}
+			i++
+		case '{':
+			d++
+		case '}':
+			if d == 0 {
+				return i
+			}
+			d--
+		}
+	}
+	return -1
+}
+
+// alternatives splits body at commas outside nested groups, nil if
+// there are none.
+func alternatives(body string, glob bool) []string {
+	var (
+		r    []string
+		d, x int
+	)
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case '\\':
+			if glob && strings.HasPrefix(body[i:], `\{`) {
+				d++
+			}
+			i++
+		case '{':
+			d++
+		case '}':
+			d--
+		case ',':
+			if d == 0 {
+				r = append(r, body[x:i])
+				x = i + 1
+			}
+		}
+	}
+	if r == nil {
+		return nil
+	}
+	return append(r, body[x:])
+}
+
+// ranges returns the items of a range x..y or x..y..step, nil if body
+// is not a range.
+func ranges(body string) []string {
+	f := strings.Split(body, "..")
+	if len(f) < 2 || len(f) > 3 {
+		return nil
+	}
+
+	step := 1
+	if len(f) == 3 {
+		n, err := strconv.Atoi(f[2])
+		if err != nil {
+			return nil
+		}
+		if step = n; step < 0 {
+			step = -step
+		}
+		if step == 0 {
+			step = 1
+		}
+	}
+
+	if len(f[0]) == 1 && len(f[1]) == 1 && !isdigit(f[0][0]) && !isdigit(f[1][0]) {
+		a, b := int(f[0][0]), int(f[1][0])
+		var r []string
+		for _, v := range seq(a, b, step) {
+			r = append(r, string(rune(v)))
+		}
+		return r
+	}
+
+	a, err := strconv.Atoi(f[0])
+	if err != nil {
+		return nil
+	}
+	b, err := strconv.Atoi(f[1])
+	if err != nil {
+		return nil
+	}
+
+	// zero padded to the longer end when either end is.
+	var w int
+	if padded(f[0]) || padded(f[1]) {
+		if w = len(f[0]); len(f[1]) > w {
+			w = len(f[1])
+		}
+	}
+
+	var r []string
+	for _, v := range seq(a, b, step) {
+		r = append(r, fmt.Sprintf("%0*d", w, v))
+	}
+	return r
+}
+
+func padded(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	return len(s) > 1 && s[0] == '0'
+}
+
+func isdigit(c byte) bool {
+	return '0' <= c && c <= '9' || c == '-'
+}
+
+func seq(a, b, step int) []int {
+	var r []int
+	if a <= b {
+		for i := a; i <= b; i += step {
+			r = append(r, i)
+		}
+	} else {
+		for i := a; i >= b; i -= step {
+			r = append(r, i)
+		}
+	}
+	return r
+}
+
+// unbrace removes the escapes of commas and braces.
+func unbrace(s string, glob bool) string {
+	if glob || strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case ',', '{', '}':
+				i++
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMulti(t *testing.T) {
+	for _, v := range []struct {
+		in   string
+		glob bool
+		out  []string
+	}{
+		{"a", false, []string{"a"}},
+		{"a{b,c}d", false, []string{"abd", "acd"}},
+		{"{a,b}{1,2}", false, []string{"a1", "a2", "b1", "b2"}},
+		{"x{a,b{1,2},c}", false, []string{"xa", "xb1", "xb2", "xc"}},
+		{"file{,.x}", false, []string{"file", "file.x"}},
+		{`{a\,b,c}`, false, []string{"a,b", "c"}},
+		{`\{a,b\}`, false, []string{"{a,b}"}},
+		{"{a}", false, []string{"{a}"}},
+		{"{}", false, []string{"{}"}},
+		{"{a{1,2}}", false, []string{"{a1}", "{a2}"}},
+		{"{a,b", false, []string{"{a,b"}},
+		{"{1..3}", false, []string{"1", "2", "3"}},
+		{"{3..1}", false, []string{"3", "2", "1"}},
+		{"{08..10}", false, []string{"08", "09", "10"}},
+		{"{1..7..3}", false, []string{"1", "4", "7"}},
+		{"{-1..1}", false, []string{"-1", "0", "1"}},
+		{"{a..c}", false, []string{"a", "b", "c"}},
+		{"tty{1..2}{a,b}", false, []string{"tty1a", "tty1b", "tty2a", "tty2b"}},
+		{"{1..x}", false, []string{"{1..x}"}},
+		{`a.{2}\{b,c}`, true, []string{"a.{2}b", "a.{2}c"}},
+		{`a{2,b}`, true, []string{"a{2,b}"}},
+		{`\{a\{1,2},b}`, true, []string{"a1", "a2", "b"}},
+	} {
+		if r := multi(v.in, v.glob); !reflect.DeepEqual(r, v.out) {
+			t.Errorf("%q: %q, want %q", v.in, r, v.out)
+		}
+	}
+}
+
+func TestMultiEntry(t *testing.T) {
+	var c Config
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString(`
+l ../{a,b} links
+l ../{c,d} {x,y}
+l busybox bin/{sh,ls}
+d dir/{1..2}/{a,b}
+c {e,f}.txt - - - data
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r []string
+	for _, v := range m.A {
+		r = append(r, v.Src+" "+v.Dst)
+	}
+	want := []string{
+		"../a links/a", "../b links/b",
+		"../c x", "../d y",
+		"busybox bin/sh", "busybox bin/ls",
+		"dir/1/a dir/1/a", "dir/1/b dir/1/b", "dir/2/a dir/2/a", "dir/2/b dir/2/b",
+		"e.txt e.txt", "f.txt f.txt",
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("entries %q, want %q", r, want)
+	}
+
+	_, err = c.FromReader(context.Background(), bytes.NewBufferString("l {a,b} {1..3}\n"))
+	if err == nil || err.Error() != "2 sources, 3 destinations, line 1" {
+		t.Errorf("error: %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// Attr sets an attribute of an entry added with a Builder.
+type Attr func(*attrs)
+
+type attrs struct {
+	mode, user, group int
+}
+
+// Mode sets the permission bits of an entry.
+func Mode(mode int) Attr {
+	return func(a *attrs) {
+		a.mode = mode
+	}
+}
+
+// Owner sets the user and group id of an entry.
+func Owner(uid, gid int) Attr {
+	return func(a *attrs) {
+		a.user = uid
+		a.group = gid
+	}
+}
+
+// fields returns the attributes set in a, -1 if unset.
+func fields(a []Attr) attrs {
+	r := attrs{-1, -1, -1}
+	for _, v := range a {
+		v(&r)
+	}
+	return r
+}
+
+func (a attrs) entry(typ, src, dst string, mode int) Entry {
+	if a.mode >= 0 {
+		mode = a.mode
+	}
+	return Entry{
+		Type:  typ,
+		Src:   src,
+		Dst:   clean(dst),
+		Mode:  mode,
+		User:  def(a.user),
+		Group: def(a.group),
+	}
+}
+
+func def(i int) int {
+	if i < 0 {
+		return 0
+	}
+	return i
+}
+
+// omit formats i in base for a field, -1 is omitted.
+func omit(i, base int) string {
+	if i < 0 {
+		return TypeOmit
+	}
+	return strconv.FormatInt(int64(i), base)
+}
+
+// Builder adds entries to a Map with the semantics of the configuration
+// format without writing configuration text. Names are used as is,
+// they are not expanded, unescaped or replaced.
+// Adding stops at the first error or when the context is done, the
+// error is returned by Map.
+type Builder struct {
+	ctx context.Context
+	m   *Map
+	rel bool
+	n   int
+	err error
+}
+
+// NewBuilder returns a Builder adding entries to an empty Map.
+func (c *Config) NewBuilder(ctx context.Context) *Builder {
+	return &Builder{ctx: ctx, m: c.newMap(ctx)}
+}
+
+func (b *Builder) next() bool {
+	if b.err != nil {
+		return false
+	}
+	if b.err = b.ctx.Err(); b.err != nil {
+		return false
+	}
+	b.n++
+	b.m.line = b.n
+	return true
+}
+
+func (b *Builder) fail(err error) *Builder {
+	if err != nil {
+		b.err = fmt.Errorf("%v, entry %d", err, b.n)
+	}
+	return b
+}
+
+func (b *Builder) add(e ...string) *Builder {
+	if !b.next() {
+		return b
+	}
+	return b.fail(b.m.addFields(entry(e), false, b.n))
+}
+
+// addEntry adds E, dst reports whether the destination was set.
+func (b *Builder) addEntry(E Entry, dst bool, a attrs) *Builder {
+	if !b.next() {
+		return b
+	}
+	E.Line = b.n
+	E.File = b.m.file
+	return b.fail(b.m.addParsed(E, dst, a.user, a.group, a.mode))
+}
+
+// Rootfs makes the sources of subsequent files, recursive and glob
+// entries relative to the prefix of the Config.
+func (b *Builder) Rootfs(rel bool) *Builder {
+	b.rel = rel
+	return b
+}
+
+func (b *Builder) typ(abs, rel string) string {
+	if b.rel {
+		return rel
+	}
+	return abs
+}
+
+// File adds the file src as dst, an empty dst is the same as src.
+func (b *Builder) File(src, dst string, a ...Attr) *Builder {
+	f := fields(a)
+	set := dst != ""
+	if !set {
+		dst = src
+	}
+	return b.addEntry(f.entry(b.typ(TypeRegular, TypeRegularRel), src, dst, 0644), set, f)
+}
+
+// Dir adds the directory dst.
+func (b *Builder) Dir(dst string, a ...Attr) *Builder {
+	f := fields(a)
+	return b.addEntry(f.entry(TypeDirectory, dst, dst, 0755), true, f)
+}
+
+// Symlink adds dst as a symlink to target.
+func (b *Builder) Symlink(target, dst string, a ...Attr) *Builder {
+	f := fields(a)
+	return b.addEntry(f.entry(TypeSymlink, target, dst, 0777), true, f)
+}
+
+// Create adds dst with the contents data.
+func (b *Builder) Create(dst string, data []byte, a ...Attr) *Builder {
+	f := fields(a)
+	E := f.entry(TypeCreateNoEndl, dst, dst, 0644)
+	E.Data = data
+	return b.addEntry(E, true, f)
+}
+
+// ELF adds the ELF src from the prefix of the Config with the
+// libraries it depends on. ELFs are always added with mode 0755,
+// setting Mode is an error.
+func (b *Builder) ELF(src, dst string, a ...Attr) *Builder {
+	f := fields(a)
+	if f.mode >= 0 {
+		if b.next() {
+			b.fail(fmt.Errorf("%s: mode can not be set for ELF", src))
+		}
+		return b
+	}
+	if dst == "" {
+		dst = src
+	}
+	return b.addEntry(f.entry(TypeLinked, src, dst, 0644), true, f)
+}
+
+// Recursive adds the directory src as dst with everything under it.
+func (b *Builder) Recursive(src, dst string, a ...Attr) *Builder {
+	f := fields(a)
+	if dst == "" {
+		dst = src
+	}
+	E := f.entry(b.typ(TypeRecursive, TypeRecursiveRel), src, dst, 0)
+	E.Mode = 0
+	return b.addEntry(E, true, f)
+}
+
+// Glob adds the files matching pattern under dst.
+func (b *Builder) Glob(pattern, dst string, a ...Attr) *Builder {
+	f := fields(a)
+	E := f.entry(b.typ(TypeGlob, TypeGlobRel), pattern, dst, 0)
+	E.Mode = 0
+	return b.addEntry(E, true, f)
+}
+
+// Mask sets the attributes of subsequent entries matching the
+// regexp re.
+func (b *Builder) Mask(re string, a ...Attr) *Builder {
+	f := fields(a)
+	return b.add(maskMode, TypeOmit, re, omit(f.mode, 8), omit(f.user, 10), omit(f.group, 10))
+}
+
+// Rename replaces the part of subsequent destinations matching
+// the regexp re with dst.
+func (b *Builder) Rename(re, dst string) *Builder {
+	return b.add(maskReplace, TypeOmit, re, dst)
+}
+
+// Ignore skips subsequent entries matching the regexp re.
+func (b *Builder) Ignore(re string) *Builder {
+	return b.add(maskIgnore, TypeOmit, re)
+}
+
+// IgnoreNot skips subsequent entries not matching the regexp re.
+func (b *Builder) IgnoreNot(re string) *Builder {
+	return b.add(maskIgnoreNeg, TypeOmit, re)
+}
+
+// ClearMasks removes all masks.
+func (b *Builder) ClearMasks() *Builder {
+	return b.add(maskClear)
+}
+
+// Map returns the Map built, waiting for concurrently resolved ELFs.
+func (b *Builder) Map() (*Map, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.m.c.Opt.ELF.Concurrent {
+		if err := b.m.includeElfs(); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return b.m, nil
+}
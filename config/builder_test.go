@@ -0,0 +1,109 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_builder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, v := range []string{"a", "b", "d/e"} {
+		p := path.Join(tmp, "src", v)
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var c Config
+	c.Prefix = tmp
+
+	want, err := c.FromReader(context.Background(), bytes.NewBufferString(`
+mm - ^opt/ 0700 5 6
+d opt
+mc
+f src/a{,.x} - 0600 1 2
+d dir 0750
+l ../a dir/link
+cl file\ name - - - data
+Rr src/d rec
+mi - b$
+rr src/[ab] glob/
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := c.NewBuilder(context.Background()).
+		Mask("^opt/", Mode(0700), Owner(5, 6)).
+		Dir("opt").
+		ClearMasks().
+		File("src/a", "", Mode(0600), Owner(1, 2)).
+		File("src/a.x", "", Mode(0600), Owner(1, 2)).
+		Dir("dir", Mode(0750)).
+		Symlink("../a", "dir/link").
+		Create("file name", []byte("data")).
+		Rootfs(true).
+		Recursive("src/d", "rec").
+		Ignore("b$").
+		Glob("src/[ab]", "glob/").
+		Map()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k := range want.A {
+		want.A[k].Line = 0
+	}
+	for k := range m.A {
+		m.A[k].Line = 0
+	}
+	if !reflect.DeepEqual(m.A, want.A) {
+		t.Errorf("have:\n%+v\nwant:\n%+v", m.A, want.A)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.NewBuilder(ctx).Dir("a").Map(); err != context.Canceled {
+		t.Errorf("cancel: %v", err)
+	}
+	if _, err := c.NewBuilder(context.Background()).Mask("(").Dir("a").Map(); err == nil {
+		t.Error("invalid regexp accepted")
+	}
+}
+
+func TestBuilderLiteral(t *testing.T) {
+	var c Config
+	m, err := c.NewBuilder(context.Background()).
+		Dir("{a,b}").
+		Symlink("$x", "a\\ b").
+		Create("c,d", []byte("  $(x) \\{\n")).
+		Map()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Type: TypeDirectory, Src: "{a,b}", Dst: "{a,b}", Mode: 0755, Line: 1},
+		{Type: TypeSymlink, Src: "$x", Dst: "a\\ b", Mode: 0777, Line: 2},
+		{Type: TypeCreateNoEndl, Src: "c,d", Dst: "c,d", Mode: 0644, Line: 3, Data: []byte("  $(x) \\{\n")},
+	}
+	if !reflect.DeepEqual(m.A, want) {
+		t.Errorf("have:\n%+v\nwant:\n%+v", m.A, want)
+	}
+
+	if _, err := c.NewBuilder(context.Background()).ELF("a", "", Mode(0700)).Map(); err == nil {
+		t.Error("ELF mode accepted")
+	}
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	condIf     = "if"
+	condElse   = "else"
+	condEnd    = "end"
+	condExists = "exists"
+	condEq     = "eq"
+)
+
+var (
+	errNoIf  = errors.New("no matching if")
+	errNoEnd = errors.New("if without end")
+)
+
+// block is an if block being read.
+type block struct {
+	line  int
+	skip  bool // entries of the block are skipped.
+	outer bool // the block is inside a skipped block.
+	els   bool
+	loop  bool // a skipped for or def.
+}
+
+type blocks []block
+
+func (b blocks) skip() bool {
+	return len(b) > 0 && b[len(b)-1].skip
+}
+
+// cond handles e if it is a conditional, reporting whether it was.
+func (m *Map) cond(b *blocks, e entry, line int) (bool, error) {
+	switch e[idxType] {
+	case loopFor, macroDef:
+		// loops and definitions inside skipped blocks are skipped.
+		if !b.skip() {
+			return false, nil
+		}
+		*b = append(*b, block{line: line, skip: true, outer: true, loop: true})
+		return true, nil
+
+	case condIf:
+		outer := b.skip()
+		if outer {
+			*b = append(*b, block{line: line, skip: true, outer: true})
+			return true, nil
+		}
+		ok, err := m.eval(e[1:])
+		*b = append(*b, block{line: line, skip: !ok})
+		return true, err
+
+	case condElse:
+		if len(e) > 1 {
+			return true, errInvalidEntry
+		}
+		if len(*b) == 0 {
+			return true, errNoIf
+		}
+		x := &(*b)[len(*b)-1]
+		if x.loop {
+			return true, errNoIf
+		}
+		if x.els {
+			return true, fmt.Errorf("else after else, if on line %d", x.line)
+		}
+		x.els = true
+		x.skip = x.outer || !x.skip
+		return true, nil
+
+	case condEnd:
+		if len(e) > 1 {
+			return true, errInvalidEntry
+		}
+		if len(*b) == 0 {
+			return true, errNoIf
+		}
+		*b = (*b)[:len(*b)-1]
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// eval evaluates the condition of an if entry:
+//
+//	$name        name is set and not empty
+//	exists path  path exists under the rootfs
+//	eq a b       a and b are equal after replacing variables
+func (m *Map) eval(c []string) (bool, error) {
+	switch {
+	case len(c) == 1 && strings.HasPrefix(c[0], TypeVariable):
+		name := c[0][len(TypeVariable):]
+		if strings.HasPrefix(name, "{") && strings.HasSuffix(name, "}") {
+			name = name[1 : len(name)-1]
+		}
+		v, ok := m.v.lookup(name)
+		return ok && v != "", nil
+
+	case len(c) == 2 && c[0] == condExists:
+		p, err := m.v.expand(c[1])
+		if err != nil {
+			return false, err
+		}
+		_, err = os.Lstat(path.Join(m.prefix, p))
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return err == nil, err
+
+	case len(c) == 3 && c[0] == condEq:
+		a, err := m.v.expand(c[1])
+		if err != nil {
+			return false, err
+		}
+		b, err := m.v.expand(c[2])
+		return a == b, err
+	}
+
+	if len(c) == 0 {
+		return false, errNoArguments
+	}
+	return false, fmt.Errorf("invalid condition: %s", strings.Join(c, " "))
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCond(t *testing.T) {
+	c := Config{Vars: []string{"arch", "x86_64"}}
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString(`
+$ debug 1
+$ empty
+if $debug
+	mm - ^ 0600
+	d debug
+	if eq $arch aarch64
+		d arm
+	else
+		d x86
+		$ lib lib64
+	end
+else
+	d release
+end
+
+if $empty
+	c empty - - - <<EOF
+end
+else
+EOF
+else
+	c heredoc - - - <<EOF
+if $debug
+end
+EOF
+end
+
+if exists `+os.DevNull+`
+	l {
+		a
+		b
+	} links
+	mc
+end
+if exists /nonexistent
+	d nonexistent
+end
+if $undefined
+	d undefined
+end
+d $lib
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		dst  string
+		mode int
+	}{
+		{"debug", 0600},
+		{"x86", 0600},
+		{"heredoc", 0600},
+		{"links/a", 0600},
+		{"links/b", 0600},
+		{"lib64", 0755},
+	}
+	if len(m.A) != len(want) {
+		t.Fatalf("entries: %+v", m.A)
+	}
+	for k, v := range want {
+		if m.A[k].Dst != v.dst || m.A[k].Mode != v.mode {
+			t.Errorf("entry %d: %+v, want %s %o", k, m.A[k], v.dst, v.mode)
+		}
+	}
+	if string(m.A[2].Data) != "if 1\nend\n" {
+		t.Errorf("heredoc: %q", m.A[2].Data)
+	}
+
+	for cfg, want := range map[string]string{
+		"if $a\n":               "if without end, line 1",
+		"end\n":                 "no matching if, line 1",
+		"if $a\nelse\nelse\n":   "else after else, if on line 1, line 3",
+		"if foo $a\nend\n":      "invalid condition: foo $a, line 1",
+		"if\nend\n":             "no arguments, line 1",
+		"if $a\n\tbad\nend\n":   "",
+		"if eq a a\n\tbad\nend": "no arguments, line 2",
+	} {
+		_, err := c.FromReader(context.Background(), bytes.NewBufferString(cfg))
+		if err == nil && want != "" || err != nil && err.Error() != want {
+			t.Errorf("%q: %v, want %q", cfg, err, want)
+		}
+	}
+}
+
+func TestCondRootfs(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_cond")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := ioutil.WriteFile(path.Join(tmp, "x"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := Config{Prefix: tmp}
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString(`
+if exists /x
+	d x
+end
+if exists `+tmp+`
+	d host
+end
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.A) != 1 || m.A[0].Dst != "x" {
+		t.Errorf("entries: %+v", m.A)
+	}
+}
@@ -1,12 +1,13 @@
 package config
 
 import (
-	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
-	"strings"
+	"path/filepath"
+	"sync"
 
 	"github.com/tlahdekorpi/archivegen/elf"
 )
@@ -17,179 +18,68 @@ var (
 	errNoArguments  = errors.New("no arguments")
 )
 
-type split rune
-
-func (s *split) split(r rune) bool {
-	ret := rune(*s) != '\\' && r == ' ' || r == '\t'
-	*s = split(r)
-	return ret
+type lineError struct {
+	line int
+	err  error
 }
 
-func fieldsFuncN(s string, n int, f func(rune) bool) []string {
-	var (
-		c   int
-		inN bool
-		inB bool
-	)
-
-	// Count fields to avoid allocations.
-	for _, v := range s {
-		if n > 0 && c >= n {
-			break
-		}
-
-		inB = !inN
-		inN = !f(v)
-		if inN && inB {
-			c++
-		}
-	}
-
-	// Create slice with the expected length.
-	ret := make([]string, c)
-
-	var (
-		na int
-		fs int
-		is bool
-	)
-
-	// Set to -1 when looking for start of field.
-	fs = -1
-
-	for k, v := range s {
-		// After Nth field, all remaining is the last field.
-		if n > 0 && na >= c-1 {
-			fs = k
-			break
-		}
-
-		is = f(v)
-
-		// In and not at start
-		if is && fs > -1 {
-			ret[na] = s[fs:k]
-			na++
-			fs = -1
-		}
-
-		// Not in and looking for start.
-		if !is && fs < 0 {
-			fs = k
-		}
-	}
-
-	// Add last field.
-	if fs > -1 {
-		ret[na] = s[fs:]
+func (l lineError) Error() string {
+	switch l.err.(type) {
+	case includeError, macroError:
+		return fmt.Sprintf("line %d: %v", l.line, l.err)
 	}
-
-	return ret
+	return fmt.Sprintf("%s, line %d", l.err.Error(), l.line)
 }
 
-func isheredoc(data []string) string {
-	const idx = idxData - 1
-
-	if len(data) < idxData {
-		return ""
-	}
-
-	if len(data[idx]) <= 2 {
-		return ""
-	}
-
-	x := strings.TrimLeft(data[idx], " \t")
-	if x[:2] != "<<" {
-		return ""
-	}
-
-	return x[2:]
+// includeError is an error on a line of an included file, nested
+// includes format as a file:line chain.
+type includeError struct {
+	file string
+	line int
+	err  error
 }
 
-var multiReplace = strings.NewReplacer(
-	"\t", "",
-	"\n", ",",
-).Replace
+func (e includeError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.file, e.line, e.err)
+}
 
-func multireader(s *bufio.Scanner, entry []string, eof string, t byte) ([]string, int, error) {
-	var (
-		idx int
-		ret string
-	)
+// errorList is the errors of reading a file.
+type errorList []error
 
-	switch t {
-	case 'c':
-		idx = idxData - 1
-	case 'l':
-		idx = idxDst
-		if len(entry) <= idxDst {
-			idx = idxSrc
-		}
-		ret = entry[idx]
-	default:
-		idx = idxSrc
-		ret = entry[idx]
+// add appends err of line n, errors of includes and loops are
+// flattened.
+func (e *errorList) add(n int, err error) {
+	l, ok := err.(errorList)
+	if !ok {
+		l = errorList{err}
 	}
-
-	var (
-		n int
-		r string
-	)
-
-	for s.Scan() {
-		n++
-
-		if err := s.Err(); err != nil {
-			return entry, n, err
-		}
-
-		r = s.Text()
-
-		switch t {
-		case 'c':
-			if r != eof {
-				ret += r + "\n"
-				continue
-			}
-		default:
-			r = strings.TrimSpace(r)
-			if len(r) == 0 {
-				continue
-			}
-			if r[0] == '#' {
-				continue
-			}
-			if r[0] != '}' {
-				ret += r + "\n"
-				continue
-			}
+	for _, v := range l {
+		if _, ok := v.(lineError); !ok {
+			v = lineError{n, v}
 		}
-
-		break
+		*e = append(*e, v)
 	}
-
-	ret = strings.TrimSuffix(ret, "\n")
-
-	if t != 'c' {
-		rr := fieldsFuncN(r, -1, new(split).split)
-		ret += rr[0]
-		ret = multiReplace(ret)
-		entry = append(entry, rr[1:]...)
-	} else {
-		entry = append(entry, eof)
-	}
-
-	entry[idx] = ret
-	return entry, n, nil
 }
 
-type lineError struct {
-	line int
-	err  error
+func (e errorList) Error() string {
+	r := make(multiError, len(e))
+	for k, v := range e {
+		r[k] = v.Error()
+	}
+	return r.Error()
 }
 
-func (l lineError) Error() string {
-	return fmt.Sprintf("%s, line %d", l.err.Error(), l.line)
+// prefix returns the errors of err with s prepended.
+func prefix(s string, err error) multiError {
+	r, ok := err.(multiError)
+	if !ok {
+		return multiError{s + err.Error()}
+	}
+	x := make(multiError, len(r))
+	for k, v := range r {
+		x[k] = s + v
+	}
+	return x
 }
 
 func failable(e []string) (ok bool) {
@@ -203,107 +93,220 @@ type Config struct {
 	Resolver *elf.Resolver
 	Prefix   string
 	Vars     []string
-}
+	Opt      Options
 
-func (c *Config) FromReader(r io.Reader) (*Map, error) {
-	s := bufio.NewScanner(r)
-	m := c.newMap()
+	mu    sync.Mutex
+	q     chan struct{}
+	added map[string]struct{}
+	lint  *lint
+}
 
-	var n int
-	for s.Scan() {
-		n++
-		if err := s.Err(); err != nil {
-			return nil, lineError{n, err}
-		}
+// linter returns the lint findings with Opt.Lint, nil otherwise.
+func (c *Config) linter() *lint {
+	if !c.Opt.Lint {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lint == nil {
+		c.lint = newLint()
+	}
+	return c.lint
+}
 
-		d := s.Text()
-		if len(d) < 1 {
-			continue
-		}
-		if d[0] == '\n' || d[0] == '#' {
-			continue
+// queue returns the semaphore limiting concurrent ELF resolving.
+func (c *Config) queue() chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.q == nil {
+		n := c.Opt.ELF.NumGoroutine
+		if n < 1 {
+			n = 1
 		}
+		c.q = make(chan struct{}, n)
+	}
+	return c.q
+}
 
-		var (
-			i   int
-			err error
-			f   []string
-		)
-
-		switch d[0] {
-		case 'c':
-			f = fieldsFuncN(d, idxData, new(split).split)
-			if eof := isheredoc(f); eof != "" {
-				f, i, err = multireader(s, f, eof, d[0])
-			}
-			if x := len(f); x < idxData {
-				f[x-1] = strings.TrimSpace(f[x-1])
-			}
-		default:
-			f = fieldsFuncN(d, -1, new(split).split)
-			if d[len(d)-1] == '{' {
-				f, i, err = multireader(s, f, "}", d[0])
-			}
-		}
+// once reports whether the ELF src is added for the first time.
+func (c *Config) once(src string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.added == nil {
+		c.added = make(map[string]struct{})
+	}
+	if _, ok := c.added[src]; ok {
+		return false
+	}
+	c.added[src] = struct{}{}
+	return true
+}
 
-		n += i
+// FromReader reads a configuration from r. With Opt.KeepGoing failing
+// entries are skipped and the Map of the rest is returned together with
+// the errors of every failing line.
+func (c *Config) FromReader(ctx context.Context, r io.Reader) (*Map, error) {
+	return c.fromReader(ctx, "", r)
+}
 
+func (c *Config) fromReader(ctx context.Context, name string, r io.Reader) (*Map, error) {
+	m := c.newMap(ctx)
+	m.file = name
+	if name != "" && name != "-" {
+		abs, err := filepath.Abs(name)
 		if err != nil {
-			return nil, lineError{n, err}
-		}
-
-		if len(f) < 2 && f[idxType] != maskClear {
-			return nil, lineError{n, errNoArguments}
+			return nil, err
 		}
+		m.stack = []string{abs}
+	}
 
-		fail := failable(f)
-		if err := m.add(f, fail, n); err != nil {
-			return nil, lineError{n, err}
-		}
+	errs := m.read(r)
+	if l := c.linter(); l != nil {
+		l.done(m.v)
+	}
+	if errs != nil && !c.Opt.KeepGoing {
+		return nil, errs[0]
 	}
 
-	if err := s.Err(); err != nil {
-		return nil, lineError{n, err}
+	var ret multiError
+	for _, v := range errs {
+		ret = append(ret, v.Error())
 	}
 
-	if Opt.ELF.Concurrent {
+	if c.Opt.ELF.Concurrent {
 		if err := m.includeElfs(); err != nil {
-			return nil, err
+			if !c.Opt.KeepGoing {
+				return nil, err
+			}
+			ret = append(ret, err.(multiError)...)
 		}
 	}
 
+	if len(ret) > 0 {
+		return m, ret
+	}
 	return m, nil
 }
 
-func (c *Config) FromFiles(files ...string) (*Map, error) {
-	cfg := c.newMap()
-	for k, v := range files {
-		var (
-			f   io.ReadCloser
-			err error
-		)
-		if v == "-" {
-			f = os.Stdin
-		} else {
-			f, err = os.Open(v)
+// read adds the entries of r to m. Without Opt.KeepGoing reading stops
+// at the first error.
+func (m *Map) read(r io.Reader) errorList {
+	f, perr := Parse(r)
+
+	var (
+		n    int
+		st   reader
+		errs errorList
+	)
+	for _, v := range f.Nodes {
+		n = v.End
+		if err := m.ctx.Err(); err != nil {
+			return append(errs, err)
 		}
-		if err != nil {
-			return nil, err
+		if err := m.entry(&st, v.entry(), n); err != nil {
+			errs.add(n, err)
+			if !m.c.Opt.KeepGoing {
+				return errs
+			}
 		}
+	}
 
-		m, err := c.FromReader(f)
-		if err != nil {
-			return nil, fmt.Errorf("%s (%d): %v", v, k, err)
-		}
+	if perr != nil {
+		return append(errs, perr)
+	}
+	if err := st.close(); err != nil {
+		errs.add(n, err)
+	}
+	return errs
+}
 
-		if err := cfg.Merge(m); err != nil {
-			return nil, fmt.Errorf("%s (%d): %v", v, k, err)
-		}
+// reader is the state of reading the entries of a file.
+type reader struct {
+	b    blocks
+	loop *loop
+}
+
+func (r *reader) close() error {
+	if r.loop != nil && r.loop.def != nil {
+		return lineError{r.loop.line, errNoDefEnd}
+	}
+	if r.loop != nil {
+		return lineError{r.loop.line, errNoLoopEnd}
+	}
+	if len(r.b) > 0 {
+		return lineError{r.b[len(r.b)-1].line, errNoEnd}
+	}
+	return nil
+}
+
+// entry adds the entry f read from line n.
+func (m *Map) entry(r *reader, f entry, n int) error {
+	if r.loop != nil {
+		return m.collect(r, f, n)
+	}
+	if ok, err := m.cond(&r.b, f, n); ok || err != nil {
+		return err
+	}
+	if r.b.skip() {
+		return nil
+	}
+	switch f[idxType] {
+	case loopFor:
+		return m.loop(r, f, n)
+	case macroDef:
+		return m.def(r, f, n)
+	}
+	if mac, ok := m.macros[f[idxType]]; ok {
+		return m.call(mac, f)
+	}
+	if len(f) < 2 && f[idxType] != maskClear {
+		return errNoArguments
+	}
+	return m.add(f, failable(f), n)
+}
 
-		if err := f.Close(); err != nil {
+// FromFiles reads and merges the configurations files, "-" is stdin.
+// With Opt.KeepGoing the errors of all files are returned together.
+func (c *Config) FromFiles(ctx context.Context, files ...string) (*Map, error) {
+	var (
+		cfg  = c.newMap(ctx)
+		errs multiError
+	)
+	for k, v := range files {
+		m, err := c.fromFile(ctx, v)
+		if m != nil {
+			if err := cfg.Merge(m); err != nil {
+				return nil, fmt.Errorf("%s (%d): %v", v, k, err)
+			}
+		}
+		if err == nil {
+			continue
+		}
+		if !c.Opt.KeepGoing {
 			return nil, fmt.Errorf("%s (%d): %v", v, k, err)
 		}
+		errs = append(errs, prefix(fmt.Sprintf("%s (%d): ", v, k), err)...)
 	}
 
+	if len(errs) > 0 {
+		return cfg, errs
+	}
 	return cfg, nil
 }
+
+func (c *Config) fromFile(ctx context.Context, name string) (*Map, error) {
+	if name == "-" {
+		return c.fromReader(ctx, name, os.Stdin)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := c.fromReader(ctx, name, f)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		return nil, cerr
+	}
+	return m, err
+}
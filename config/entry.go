@@ -38,6 +38,7 @@ type Entry struct {
 	Line        int
 	Data        []byte
 	LibraryPath []string
+	File        string
 }
 
 func (e entry) Type() string {
@@ -204,6 +205,19 @@ func (e entry) Mode() (int, error) {
 	return int(m), nil
 }
 
+// regexpField returns the index of the regular expression field of
+// entries of type t, or -1.
+func regexpField(t string) int {
+	switch t {
+	case maskMode, maskIgnore, maskIgnoreNeg, maskReplace, maskTime,
+		maskLibrary:
+		return idxMaskRegexp
+	case TypeArchive:
+		return idxDst
+	}
+	return -1
+}
+
 func (e entry) typeOffset(i int) int {
 	switch e.Type() {
 	case
@@ -0,0 +1,291 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// placeholders returns the range of fields of type t where a trailing
+// TypeOmit is the same as an omitted field, ok is false for types
+// without one.
+func placeholders(t string) (lo, hi int, ok bool) {
+	e := entry{t}
+	switch t {
+	case
+		TypeRegular, TypeRegularRel, TypeAuto, TypeAutoRel, TypePath,
+		TypeLibrary, TypeLinked, TypeLinkedAbs, TypeLinkedGlob,
+		TypeDirectory, TypeCreate, TypeCreateNoEndl, TypeBase64:
+		lo = idxDst
+	case
+		TypeSymlink, TypeRecursive, TypeRecursiveRel, TypeGlob, TypeGlobRel:
+		lo = idxMode
+	default:
+		return 0, 0, false
+	}
+	return lo, e.typeOffset(idxGroup), true
+}
+
+// sortBraces returns s with the alternatives of brace groups sorted.
+func sortBraces(s string, glob bool) string {
+	i, n := opening(s, glob)
+	if i < 0 {
+		return s
+	}
+	j := closing(s, i+n, glob)
+	if j < 0 {
+		return s
+	}
+	body := s[i+n : j]
+	if alts := alternatives(body, glob); alts != nil {
+		for k, v := range alts {
+			alts[k] = sortBraces(v, glob)
+		}
+		sort.Strings(alts)
+		body = strings.Join(alts, ",")
+	} else {
+		body = sortBraces(body, glob)
+	}
+	return s[:i+n] + body + "}" + sortBraces(s[j+1:], glob)
+}
+
+type row struct {
+	depth int
+	cells []string // aligned fields, nil for a line written as is.
+	text  string
+	more  []string // following lines with their indentation.
+	blank bool     // preceded by an empty line.
+}
+
+type formatter struct {
+	sort  bool
+	depth int
+	rows  []row
+}
+
+// Format writes f in the canonical format: entries indented by block
+// and fields of adjacent entries aligned to columns, trailing
+// placeholders are removed. Comments and heredocs are kept as is. With
+// sortLists the alternatives of brace groups in sources and
+// destinations are sorted.
+func (f *File) Format(w io.Writer, sortLists bool) error {
+	p := &formatter{sort: sortLists}
+
+	var (
+		c    = f.Comments
+		last int
+	)
+	for _, n := range f.Nodes {
+		for len(c) > 0 && c[0].Pos.Line < n.Pos().Line {
+			p.add(row{depth: p.depth, text: c[0].Text}, c[0].Pos.Line, &last)
+			c = c[1:]
+		}
+		var inner []Token
+		for len(c) > 0 && c[0].Pos.Line <= n.End {
+			inner, c = append(inner, c[0]), c[1:]
+		}
+		p.add(p.node(n, inner), n.Pos().Line, &last)
+		last = n.End
+	}
+	for _, v := range c {
+		p.add(row{depth: p.depth, text: v.Text}, v.Pos.Line, &last)
+	}
+
+	_, err := w.Write(p.bytes())
+	return err
+}
+
+func (p *formatter) add(r row, line int, last *int) {
+	r.blank = len(p.rows) > 0 && line > *last+1
+	p.rows = append(p.rows, r)
+	*last = line
+}
+
+func (p *formatter) node(n *Node, comments []Token) row {
+	f := make([]string, len(n.Fields))
+	for k, v := range n.Fields {
+		f[k] = v.Text
+	}
+
+	switch f[idxType] {
+	case condIf, loopFor, macroDef:
+		p.depth++
+		return row{depth: p.depth - 1, text: strings.Join(f, " ")}
+	case condElse:
+		return row{depth: p.depth - 1, text: strings.Join(f, " ")}
+	case condEnd:
+		if p.depth > 0 {
+			p.depth--
+		}
+		return row{depth: p.depth, text: strings.Join(f, " ")}
+	}
+
+	r := row{depth: p.depth}
+	t := strings.TrimPrefix(f[idxType], "?")
+	lo, hi, ok := placeholders(t)
+	if ok {
+		var skip int
+		if b := n.Brace; b != nil {
+			skip = b.Field + 1
+		}
+		if h := n.Heredoc; h != nil {
+			skip = len(f)
+		}
+		for len(f) > lo && len(f) > skip && len(f)-1 <= hi && f[len(f)-1] == TypeOmit {
+			f = f[:len(f)-1]
+		}
+	}
+
+	var items []Token
+	if b := n.Brace; b != nil {
+		items = append(items, b.Items...)
+	}
+	var sorted bool
+	if ok && p.sort {
+		sorted = p.sortFields(n, t, f, items)
+	}
+
+	b := n.Brace
+	if b == nil {
+		r.cells = f
+		if h := n.Heredoc; h != nil {
+			if h.Body != "" {
+				r.more = strings.Split(h.Body, "\n")
+			}
+			r.more = append(r.more, h.Delim)
+		}
+		return r
+	}
+
+	// comments stay with the following item.
+	type item struct {
+		text     string
+		comments []string
+	}
+	var (
+		list []item
+		next []string
+	)
+	for _, v := range items {
+		for len(comments) > 0 && comments[0].Pos.Line < v.Pos.Line {
+			next, comments = append(next, comments[0].Text), comments[1:]
+		}
+		list, next = append(list, item{v.Text, next}), nil
+	}
+	for _, v := range comments {
+		next = append(next, v.Text)
+	}
+	if sorted {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].text < list[j].text
+		})
+	}
+
+	r.cells = f[:b.Field+1]
+	indent := strings.Repeat("\t", p.depth+1)
+	for _, v := range append(list, item{comments: next}) {
+		for _, c := range v.comments {
+			r.more = append(r.more, indent+c)
+		}
+		if v.text != "" {
+			r.more = append(r.more, indent+v.text)
+		}
+	}
+	r.more = append(r.more, strings.Repeat("\t", p.depth)+
+		strings.Join(append([]string{b.Close.Text}, f[b.Field+1:]...), "  "))
+	return r
+}
+
+// sortFields sorts the brace groups of the source or destination of f
+// unless both are expanded and paired, the brace groups in items are
+// sorted and ok reports whether the items of a multi-line brace are
+// sorted.
+func (p *formatter) sortFields(n *Node, t string, f []string, items []Token) (ok bool) {
+	var glob bool
+	switch t {
+	case TypeGlob, TypeGlobRel, TypeLinkedGlob:
+		glob = true
+	}
+	e := n.entry()
+	idx := []int{idxSrc}
+	if len(e) > idxDst {
+		switch {
+		case len(multi(e[idxSrc], glob)) > 1 && len(multi(e[idxDst], false)) > 1:
+			return false
+		case t == TypeDirectory, t == TypeCreate, t == TypeCreateNoEndl, t == TypeBase64:
+		default:
+			idx = append(idx, idxDst)
+		}
+	}
+
+	for _, i := range idx {
+		g := glob && i == idxSrc
+		if b := n.Brace; b != nil && b.Field == i {
+			if g {
+				continue
+			}
+			for k, v := range items {
+				items[k].Text = sortBraces(v.Text, g)
+			}
+			ok = true
+			continue
+		}
+		if i < len(f) {
+			f[i] = sortBraces(f[i], g)
+		}
+	}
+	return ok
+}
+
+func (p *formatter) bytes() []byte {
+	var b bytes.Buffer
+	for i := 0; i < len(p.rows); {
+		// adjacent entries at the same depth are aligned, heredocs
+		// and brace lists end the group.
+		j := i + 1
+		for p.rows[i].cells != nil && j < len(p.rows) {
+			r := p.rows[j]
+			if r.cells == nil || r.blank || r.depth != p.rows[i].depth || p.rows[j-1].more != nil {
+				break
+			}
+			j++
+		}
+
+		var w []int
+		for _, r := range p.rows[i:j] {
+			for k := 0; k < len(r.cells)-1; k++ {
+				if k == len(w) {
+					w = append(w, 0)
+				}
+				if x := utf8.RuneCountInString(r.cells[k]); x > w[k] {
+					w[k] = x
+				}
+			}
+		}
+
+		for _, r := range p.rows[i:j] {
+			if r.blank {
+				b.WriteByte('\n')
+			}
+			b.WriteString(strings.Repeat("\t", r.depth))
+			if r.cells == nil {
+				b.WriteString(r.text)
+			}
+			for k, v := range r.cells {
+				b.WriteString(v)
+				if k < len(r.cells)-1 {
+					b.WriteString(strings.Repeat(" ", w[k]-utf8.RuneCountInString(v)+2))
+				}
+			}
+			b.WriteByte('\n')
+			for _, v := range r.more {
+				b.WriteString(v)
+				b.WriteByte('\n')
+			}
+		}
+		i = j
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	const in = `# header
+$ x  1
+f /etc/passwd - - - -
+f /usr/bin/{sh,bash,ash}   usr/bin 0755 - -
+?f  /etc/{c,b,a}.conf
+
+
+if $x
+d a  - 0 0
+  # inside
+    c foo - - - <<EOF
+  keep   this
+EOF
+for y in a b
+l {
+  z
+   # item
+  y
+  } dst -
+end
+else
+  mm - ^etc 0600
+end
+f {b,a} {y,x}
+# trailing
+`
+
+	for _, v := range []struct {
+		sort bool
+		out  string
+	}{
+		{false, `# header
+$   x                       1
+f   /etc/passwd
+f   /usr/bin/{sh,bash,ash}  usr/bin  0755
+?f  /etc/{c,b,a}.conf
+
+if $x
+	d  a  -  0  0
+	# inside
+	c  foo  -  -  -  <<EOF
+  keep   this
+EOF
+	for y in a b
+		l  {
+			z
+			# item
+			y
+		}  dst
+	end
+else
+	mm  -  ^etc  0600
+end
+f  {b,a}  {y,x}
+# trailing
+`},
+		{true, `# header
+$   x                       1
+f   /etc/passwd
+f   /usr/bin/{ash,bash,sh}  usr/bin  0755
+?f  /etc/{a,b,c}.conf
+
+if $x
+	d  a  -  0  0
+	# inside
+	c  foo  -  -  -  <<EOF
+  keep   this
+EOF
+	for y in a b
+		l  {
+			# item
+			y
+			z
+		}  dst
+	end
+else
+	mm  -  ^etc  0600
+end
+f  {b,a}  {y,x}
+# trailing
+`},
+	} {
+		for _, in := range []string{in, v.out} {
+			f, err := Parse(strings.NewReader(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var b bytes.Buffer
+			if err := f.Format(&b, v.sort); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != v.out {
+				t.Errorf("sort %v:\n%s\nwant:\n%s", v.sort, b.String(), v.out)
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// functions of variable expansion, paths are relative to the rootfs.
+var functions = map[string]struct {
+	args int // -1 for any number.
+	fn   func(m *variableMap, args []string) ([]string, error)
+}{
+	"basename": {1, func(m *variableMap, a []string) ([]string, error) {
+		return []string{path.Base(a[0])}, nil
+	}},
+	"dirname": {1, func(m *variableMap, a []string) ([]string, error) {
+		return []string{path.Dir(a[0])}, nil
+	}},
+	"upper": {1, func(m *variableMap, a []string) ([]string, error) {
+		return []string{strings.ToUpper(a[0])}, nil
+	}},
+	"lower": {1, func(m *variableMap, a []string) ([]string, error) {
+		return []string{strings.ToLower(a[0])}, nil
+	}},
+	"glob":     {-1, (*variableMap).glob},
+	"readlink": {1, (*variableMap).readlink},
+	"kernel":   {0, (*variableMap).kernel},
+}
+
+// paren returns the index of the ) closing the $( at the start of s.
+func paren(s string) int {
+	var d int
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '(':
+			d++
+			i++
+		case s[i] == ')':
+			if d--; d == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// call evaluates the function call s, the contents of $().
+func (m *variableMap) call(s string) ([]string, error) {
+	s, err := m.expand(s)
+	if err != nil {
+		return nil, err
+	}
+
+	f := strings.Fields(s)
+	if len(f) == 0 {
+		return nil, fmt.Errorf("invalid function: $(%s)", s)
+	}
+	fn, ok := functions[f[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown function: %s", f[0])
+	}
+	if fn.args >= 0 && len(f)-1 != fn.args {
+		return nil, fmt.Errorf("%s: %d arguments, want %d", f[0], len(f)-1, fn.args)
+	}
+
+	r, err := fn.fn(m, f[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", f[0], err)
+	}
+	return r, nil
+}
+
+// rootfs returns p under the rootfs.
+func (m *variableMap) rootfs(p string) string {
+	return filepath.Join(m.root, "/", p)
+}
+
+// glob returns the sorted matches of the patterns in the rootfs.
+func (m *variableMap) glob(a []string) ([]string, error) {
+	var r []string
+	for _, v := range a {
+		g, err := filepath.Glob(m.rootfs(v))
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range g {
+			if m.root != "" {
+				x = "/" + strings.TrimLeft(strings.TrimPrefix(x, filepath.Clean(m.root)), "/")
+			}
+			r = append(r, x)
+		}
+	}
+	sort.Strings(r)
+	return r, nil
+}
+
+// readlink returns the target of a symlink in the rootfs.
+func (m *variableMap) readlink(a []string) ([]string, error) {
+	r, err := os.Readlink(m.rootfs(a[0]))
+	if err != nil {
+		return nil, err
+	}
+	return []string{r}, nil
+}
+
+// kernel returns the version of the kernel with modules in the rootfs.
+func (m *variableMap) kernel([]string) ([]string, error) {
+	var r []string
+	for _, v := range []string{"/lib/modules", "/usr/lib/modules"} {
+		d, err := ioutil.ReadDir(m.rootfs(v))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range d {
+			if x.IsDir() && !contains(r, x.Name()) {
+				r = append(r, x.Name())
+			}
+		}
+	}
+	switch len(r) {
+	case 0:
+		return nil, fmt.Errorf("no modules in %s", m.rootfs("/lib/modules"))
+	case 1:
+		return r, nil
+	}
+	sort.Strings(r)
+	return nil, fmt.Errorf("several versions: %s", strings.Join(r, ", "))
+}
+
+func contains(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFunctions(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_function")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, v := range []string{"lib/modules/5.10.0/kernel", "etc/ssl"} {
+		if err := os.MkdirAll(filepath.Join(tmp, v), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, v := range []string{"a.conf", "b.conf"} {
+		if err := ioutil.WriteFile(filepath.Join(tmp, "etc", v), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("ssl/cert.pem", filepath.Join(tmp, "etc/cert")); err != nil {
+		t.Fatal(err)
+	}
+
+	c := Config{Prefix: tmp}
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString(`
+$ x /usr/lib/libc.so.6
+d $(basename $x)
+d $(dirname ${x})/$(upper $(basename $x))
+d lib/modules/$(kernel)
+d $(readlink /etc/cert)
+for f in $(glob /etc/*.conf)
+	d out$f
+end
+d {$(glob /etc/*.conf)}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r []string
+	for _, v := range m.A {
+		r = append(r, v.Dst)
+	}
+	want := []string{
+		"libc.so.6", "usr/lib/LIBC.SO.6", "lib/modules/5.10.0", "ssl/cert.pem",
+		"out/etc/a.conf", "out/etc/b.conf", "etc/a.conf", "etc/b.conf",
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("entries %q, want %q", r, want)
+	}
+
+	if err := os.MkdirAll(filepath.Join(tmp, "usr/lib/modules/6.1.0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for cfg, want := range map[string]string{
+		"d $(kernel)\n":        "kernel: several versions: 5.10.0, 6.1.0, line 1",
+		"d $(foo x)\n":         "unknown function: foo, line 1",
+		"d $(basename)\n":      "basename: 0 arguments, want 1, line 1",
+		"d $(basename x\n":     "unterminated function: $(basename x, line 1",
+		"d $(readlink /etc)\n": "readlink: readlink " + filepath.Join(tmp, "etc") + ": invalid argument, line 1",
+	} {
+		_, err := c.FromReader(context.Background(), bytes.NewBufferString(cfg))
+		if err == nil || err.Error() != want {
+			t.Errorf("%q: %v, want %q", cfg, err, want)
+		}
+	}
+}
+
+func TestFunctionsData(t *testing.T) {
+	var c Config
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString(`
+$ dir /lib/modules
+c init 0755 - - <<EOF
+#!/bin/sh
+v=$(uname -r)
+ls $dir/$(uname -r) ${dir}
+EOF
+cl version - - - $(cat /proc/version)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"#!/bin/sh\nv=$(uname -r)\nls /lib/modules/$(uname -r) ${dir}\n",
+		"$(cat /proc/version)",
+	}
+	if len(m.A) != len(want) {
+		t.Fatalf("entries: %+v", m.A)
+	}
+	for k, v := range want {
+		if string(m.A[k].Data) != v {
+			t.Errorf("%s: %q, want %q", m.A[k].Dst, m.A[k].Data, v)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// include reads the files matching the pattern of e relative to the
+// including file into m. Variables are shared with the including file,
+// masks of an included file apply only to its own entries.
+func (m *Map) include(e entry, fail bool) error {
+	if len(e) > 2 {
+		return errInvalidEntry
+	}
+
+	p := e[idxSrc]
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(filepath.Dir(m.file), p)
+	}
+
+	files, err := filepath.Glob(p)
+	if err != nil {
+		return err
+	}
+	if l := m.c.linter(); l != nil && fail {
+		l.exists(pos{m.file, m.line}, len(files) > 0)
+	}
+	if len(files) == 0 {
+		if fail {
+			return nil
+		}
+		if _, err := os.Stat(p); err != nil {
+			return err
+		}
+		return fmt.Errorf("include %s: no matches", p)
+	}
+
+	var errs errorList
+	for _, v := range files {
+		err := m.includeFile(v)
+		if err == nil {
+			continue
+		}
+		l, ok := err.(errorList)
+		if !ok {
+			return err
+		}
+		errs = append(errs, l...)
+		if !m.c.Opt.KeepGoing {
+			break
+		}
+	}
+
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+func (m *Map) includeFile(file string) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	for k, v := range m.stack {
+		if v == abs {
+			return fmt.Errorf("include cycle: %s -> %s",
+				strings.Join(m.stack[k:], " -> "), abs)
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// copied, the included file may replace masks in place.
+	mm := make(maskMap, len(m.mm))
+	copy(mm, m.mm)
+
+	name, line := m.file, m.line
+	m.file, m.stack = file, append(m.stack, abs)
+	errs := m.read(f)
+	m.mm, m.file, m.line, m.stack = mm, name, line, m.stack[:len(m.stack)-1]
+
+	for k, v := range errs {
+		if l, ok := v.(lineError); ok {
+			errs[k] = includeError{file, l.line, l.err}
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
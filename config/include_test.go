@@ -0,0 +1,124 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestInclude(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for k, v := range map[string]string{
+		"main.archive": `$ v top
+mm - ^ 0600
+include inc/*.archive
+?include none/*.archive
+d after-$v-$w
+`,
+		"inc/a.archive": `mm - ^a 0700
+d a-$v
+$ w child
+`,
+		"inc/b.archive": `d b
+`,
+		"cycle.archive": `include cyc/c.archive
+`,
+		"cyc/c.archive": `d c
+
+include ../cycle.archive
+`,
+		"missing.archive": `include none.archive
+`,
+	} {
+		p := path.Join(tmp, k)
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var c Config
+	m, err := c.FromFiles(context.Background(), path.Join(tmp, "main.archive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		dst  string
+		mode int
+		file string
+		line int
+	}{
+		{"a-top", 0700, "inc/a.archive", 2},
+		{"b", 0600, "inc/b.archive", 1},
+		{"after-top-child", 0600, "main.archive", 5},
+	}
+	if len(m.A) != len(want) {
+		t.Fatalf("entries: %+v", m.A)
+	}
+	for k, v := range want {
+		e := m.A[k]
+		if e.Dst != v.dst || e.Mode != v.mode ||
+			e.File != path.Join(tmp, v.file) || e.Line != v.line {
+			t.Errorf("entry %d: %+v", k, e)
+		}
+	}
+
+	for _, v := range []struct{ file, err string }{
+		{"cycle.archive", "line 1: " + tmp + "/cyc/c.archive:3: include cycle: " +
+			tmp + "/cycle.archive -> " + tmp + "/cyc/c.archive -> " + tmp + "/cycle.archive"},
+		{"missing.archive", "stat " + tmp + "/none.archive: no such file or directory, line 1"},
+	} {
+		p := path.Join(tmp, v.file)
+		_, err := c.FromFiles(context.Background(), p)
+		if want := p + " (0): " + v.err; err == nil || err.Error() != want {
+			t.Errorf("%s: %v\nwant %s", v.file, err, want)
+		}
+	}
+}
+
+func TestIncludeMasks(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for k, v := range map[string]string{
+		"main.archive": `mm - ^ 0600
+include inc.archive
+d after
+`,
+		"inc.archive": `mm 0 ^ 0700
+d inc
+`,
+	} {
+		if err := ioutil.WriteFile(path.Join(tmp, k), []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var c Config
+	m, err := c.FromFiles(context.Background(), path.Join(tmp, "main.archive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"inc": 0700, "after": 0600}
+	if len(m.A) != len(want) {
+		t.Fatalf("entries: %+v", m.A)
+	}
+	for _, e := range m.A {
+		if e.Mode != want[e.Dst] {
+			t.Errorf("%s: mode %04o, want %04o", e.Dst, e.Mode, want[e.Dst])
+		}
+	}
+}
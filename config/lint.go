@@ -0,0 +1,264 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// pos is the configuration file and line of an entry.
+type pos struct {
+	file string
+	line int
+}
+
+func (p pos) String() string {
+	if p.file == "" {
+		return "line " + strconv.Itoa(p.line)
+	}
+	return p.file + ":" + strconv.Itoa(p.line)
+}
+
+// lintLine is the entries added by a line.
+type lintLine struct {
+	added, replaced int
+	by              pos
+}
+
+type lintMask struct {
+	pos
+	id      int
+	t       string
+	matched bool
+}
+
+// lintCombo is a combination of mI masks applied together.
+type lintCombo struct {
+	masks  []*lintMask
+	passed bool
+}
+
+type lintVar struct {
+	pos
+	name string
+	used bool
+}
+
+// lint collects the findings of Opt.Lint while reading, Config.Lint
+// reports them.
+type lint struct {
+	mu       sync.Mutex
+	lines    map[pos]*lintLine
+	masks    []*lintMask
+	combos   map[string]*lintCombo
+	optional map[pos]bool
+	unused   []*lintVar
+
+	// mI masks of the entry being applied.
+	round []*lintMask
+	pass  bool
+}
+
+func newLint() *lint {
+	return &lint{
+		lines:    make(map[pos]*lintLine),
+		combos:   make(map[string]*lintCombo),
+		optional: make(map[pos]bool),
+	}
+}
+
+func (l *lint) line(p pos) *lintLine {
+	x, ok := l.lines[p]
+	if !ok {
+		x = new(lintLine)
+		l.lines[p] = x
+	}
+	return x
+}
+
+// insert counts the entry e added by its line.
+func (l *lint) insert(e Entry) {
+	if e.Line == 0 {
+		return
+	}
+	l.mu.Lock()
+	l.line(pos{e.File, e.Line}).added++
+	l.mu.Unlock()
+}
+
+// replace counts e1 replaced by e2, entries replaced by their own line
+// are ignored.
+func (l *lint) replace(e1, e2 Entry) {
+	p1, p2 := pos{e1.File, e1.Line}, pos{e2.File, e2.Line}
+	if e1.Line == 0 || p1 == p2 {
+		return
+	}
+	l.mu.Lock()
+	x := l.line(p1)
+	x.replaced++
+	x.by = p2
+	l.mu.Unlock()
+}
+
+// exists records whether the source of an optional entry exists.
+func (l *lint) exists(p pos, ok bool) {
+	l.mu.Lock()
+	if v, seen := l.optional[p]; !seen || v {
+		l.optional[p] = ok
+	}
+	l.mu.Unlock()
+}
+
+// mask returns f recording the entries matching the regexp of e.
+func (l *lint) mask(e entry, f maskFunc, p pos) maskFunc {
+	r, err := regexp.Compile(e[idxMaskRegexp])
+	if err != nil {
+		return f
+	}
+
+	l.mu.Lock()
+	x := &lintMask{pos: p, id: len(l.masks), t: e.Type()}
+	l.masks = append(l.masks, x)
+	l.mu.Unlock()
+
+	return func(E *Entry) bool {
+		if r.MatchString(E.Dst) {
+			x.matched = true
+		}
+		ign := f(E)
+		if x.t == maskIgnoreNeg {
+			l.round = append(l.round, x)
+			l.pass = l.pass && !ign
+		}
+		return ign
+	}
+}
+
+// apply applies every mask of mm to e, the combination of mI masks is
+// recorded.
+func (l *lint) apply(mm maskMap, e *Entry) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.round, l.pass = l.round[:0], true
+
+	// masks after an ignoring one are applied as well, the
+	// entry is dropped either way.
+	var ign bool
+	for _, v := range mm {
+		if v(e) {
+			ign = true
+		}
+	}
+
+	if len(l.round) > 1 {
+		id := make([]string, len(l.round))
+		for k, v := range l.round {
+			id[k] = strconv.Itoa(v.id)
+		}
+		k := strings.Join(id, ",")
+		c, ok := l.combos[k]
+		if !ok {
+			c = &lintCombo{masks: append([]*lintMask(nil), l.round...)}
+			l.combos[k] = c
+		}
+		c.passed = c.passed || l.pass
+	}
+	return ign
+}
+
+// define records the definition of variable name, a definition
+// replaced before it is used is unused.
+func (l *lint) define(v *variableMap, name string, p pos) {
+	if x, ok := v.defs[name]; ok && !x.used {
+		l.mu.Lock()
+		l.unused = append(l.unused, x)
+		l.mu.Unlock()
+	}
+	v.defs[name] = &lintVar{pos: p, name: name}
+}
+
+// done records the unused variables of v.
+func (l *lint) done(v *variableMap) {
+	l.mu.Lock()
+	for _, x := range v.defs {
+		if !x.used {
+			l.unused = append(l.unused, x)
+		}
+	}
+	l.mu.Unlock()
+	v.defs = make(map[string]*lintVar)
+}
+
+type finding struct {
+	pos
+	msg string
+}
+
+// Lint returns the findings of Opt.Lint sorted by file and line:
+// entries replaced by later entries, masks that match no entry, unused
+// variables, optional entries whose source exists and combinations of
+// mI masks that no entry passes.
+func (c *Config) Lint() []string {
+	l := c.lint
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var r []finding
+	for k, v := range l.lines {
+		if v.added > 0 && v.replaced == v.added {
+			r = append(r, finding{k, "replaced by " + v.by.String()})
+		}
+	}
+	for _, v := range l.masks {
+		if !v.matched {
+			r = append(r, finding{v.pos, fmt.Sprintf("mask %s never matches", v.t)})
+		}
+	}
+	for _, v := range l.combos {
+		if v.passed {
+			continue
+		}
+		var (
+			s  []string
+			ok = true
+		)
+		for _, x := range v.masks[:len(v.masks)-1] {
+			ok = ok && x.matched
+			s = append(s, x.pos.String())
+		}
+		// masks that never match are already reported.
+		if x := v.masks[len(v.masks)-1]; ok && x.matched {
+			r = append(r, finding{x.pos, "unreachable mI combination with " + strings.Join(s, ", ")})
+		}
+	}
+	for _, v := range l.unused {
+		r = append(r, finding{v.pos, "unused variable: " + v.name})
+	}
+	for k, v := range l.optional {
+		if v {
+			r = append(r, finding{k, "optional entry always exists"})
+		}
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].file != r[j].file {
+			return r[i].file < r[j].file
+		}
+		if r[i].line != r[j].line {
+			return r[i].line < r[j].line
+		}
+		return r[i].msg < r[j].msg
+	})
+	s := make([]string, len(r))
+	for k, v := range r {
+		s[k] = v.String() + ": " + v.msg
+	}
+	return s
+}
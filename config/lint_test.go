@@ -0,0 +1,60 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	var c Config
+	c.Opt.Lint = true
+	_, err := c.FromReader(context.Background(), bytes.NewBufferString(`
+$ unused 1
+$ used etc
+$ twice a
+$ twice b
+d $twice
+d x 0700
+mm - ^nomatch 0600
+mm - ^x 0700
+d x 0755
+?f `+os.DevNull+` null
+?f /nonexistent none
+mI - ^usr
+mI - ^${used}
+d usr/bin
+d $used/foo
+mc
+d {a,b}/c
+d a/c
+for l in a a
+	d loop
+end
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"line 2: unused variable: unused",
+		"line 4: unused variable: twice",
+		"line 7: replaced by line 10",
+		"line 8: mask mm never matches",
+		"line 11: optional entry always exists",
+		"line 14: unreachable mI combination with line 13",
+	}
+	if r := c.Lint(); !reflect.DeepEqual(r, want) {
+		t.Errorf("lint:\n%q\nwant:\n%q", r, want)
+	}
+
+	c = Config{}
+	if _, err := c.FromReader(context.Background(), bytes.NewBufferString("$ a\nd x\nd x\n")); err != nil {
+		t.Fatal(err)
+	}
+	if r := c.Lint(); r != nil {
+		t.Errorf("without lint: %q", r)
+	}
+}
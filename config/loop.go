@@ -0,0 +1,145 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	loopFor  = "for"
+	loopIn   = "in"
+	loopList = "list"
+)
+
+var errNoLoopEnd = errors.New("for without end")
+
+type stmt struct {
+	f entry
+	n int
+}
+
+// loop is a for block or macro definition being read.
+type loop struct {
+	name  string
+	items []string
+	line  int
+	depth int // blocks inside the body.
+	body  []stmt
+
+	// definition of a macro, defined when the body is read.
+	def *macro
+}
+
+// items returns the items of a for entry, references to list variables
+// and function calls are replaced by the items of the list.
+func (m *Map) items(f []string) ([]string, error) {
+	var r []string
+	for _, v := range f {
+		if strings.HasPrefix(v, "$(") && paren(v) == len(v)-1 {
+			x, err := m.v.call(v[2 : len(v)-1])
+			if err != nil {
+				return nil, err
+			}
+			r = append(r, x...)
+			continue
+		}
+		if strings.HasPrefix(v, TypeVariable) {
+			name := strings.TrimSuffix(strings.TrimPrefix(v[1:], "{"), "}")
+			if x, ok := m.v.m[name]; ok && x.list != nil {
+				m.v.use(name)
+				r = append(r, x.list...)
+				continue
+			}
+		}
+		x, err := m.v.expand(v)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, x)
+	}
+	return r, nil
+}
+
+// loop starts reading the body of a for entry:
+//
+//	for name in items...
+func (m *Map) loop(r *reader, f entry, n int) error {
+	if len(f) < 3 || f[2] != loopIn {
+		return fmt.Errorf("invalid loop: %s", strings.Join(f, " "))
+	}
+	name := f[1]
+	for k := 0; k < len(name); k++ {
+		if !isname(name[k], k == 0) {
+			return fmt.Errorf("invalid loop variable: %s", name)
+		}
+	}
+	items, err := m.items(f[3:])
+	if err != nil {
+		return err
+	}
+	r.loop = &loop{name: name, items: items, line: n}
+	return nil
+}
+
+// collect adds f to the body of the current loop, the loop is run when
+// its end is read.
+func (m *Map) collect(r *reader, f entry, n int) error {
+	l := r.loop
+	switch f[idxType] {
+	case condIf, loopFor, macroDef:
+		l.depth++
+	case condEnd:
+		if l.depth == 0 {
+			r.loop = nil
+			if l.def != nil {
+				l.def.body = l.body
+				m.macros[l.def.name] = l.def
+				return nil
+			}
+			return m.run(l)
+		}
+		l.depth--
+	}
+	l.body = append(l.body, stmt{f, n})
+	return nil
+}
+
+// run adds the body of l for every item, the loop variable is restored
+// afterwards.
+func (m *Map) run(l *loop) error {
+	prev, ok := m.v.m[l.name]
+	defer func() {
+		if ok {
+			m.v.m[l.name] = prev
+		} else {
+			delete(m.v.m, l.name)
+		}
+	}()
+
+	var errs errorList
+	for _, v := range l.items {
+		if err := m.ctx.Err(); err != nil {
+			return err
+		}
+		m.v.m[l.name] = variable{value: v}
+
+		var r reader
+		for _, s := range l.body {
+			if err := m.entry(&r, append(entry(nil), s.f...), s.n); err != nil {
+				errs.add(s.n, err)
+				if !m.c.Opt.KeepGoing {
+					return errs
+				}
+			}
+		}
+		if err := r.close(); err != nil {
+			errs.add(l.line, err)
+		}
+	}
+
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
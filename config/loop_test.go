@@ -0,0 +1,84 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestLoop(t *testing.T) {
+	var c Config
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString(`
+list locales en de fi
+$ x outer ignored
+d {$locales}
+for l in $locales extra
+	if eq $l de
+		mm - ^de 0700
+	end
+	d locale/$l
+	for a in amd64 ${l}64
+		l ../$l $a/$l
+	end
+	c $l.txt - - - <<EOF
+$l
+end
+EOF
+end
+d $x
+for x in
+	d never
+end
+if eq a b
+	for x in a b
+		if eq a a
+		end
+		d skipped
+	end
+end
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r []string
+	for _, v := range m.A {
+		r = append(r, v.Dst)
+	}
+	want := []string{
+		"en", "de", "fi",
+		"locale/en", "amd64/en", "en64/en", "en.txt",
+		"locale/de", "amd64/de", "de64/de", "de.txt",
+		"locale/fi", "amd64/fi", "fi64/fi", "fi.txt",
+		"locale/extra", "amd64/extra", "extra64/extra", "extra.txt",
+		"outer",
+	}
+	if len(r) != len(want) {
+		t.Fatalf("entries: %q", r)
+	}
+	for k, v := range want {
+		if r[k] != v {
+			t.Errorf("entry %d: %s, want %s", k, r[k], v)
+		}
+	}
+
+	if e := m.A[m.m["en.txt"]]; string(e.Data) != "en\nend\n" {
+		t.Errorf("heredoc: %q", e.Data)
+	}
+	if e := m.A[m.m["de64/de"]]; e.Mode != 0700 || e.Line != 11 {
+		t.Errorf("mask: %+v", e)
+	}
+
+	for cfg, want := range map[string]string{
+		"for x in a\n":                            "for without end, line 1",
+		"for x a\nend\n":                          "invalid loop: for x a, line 1",
+		"for 1 in a\nend\n":                       "invalid loop variable: 1, line 1",
+		"for x in a b\n\tbad\nend\n":              "no arguments, line 2",
+		"if eq a b\nfor x in a\nelse\nend\nend\n": "no matching if, line 3",
+	} {
+		_, err := c.FromReader(context.Background(), bytes.NewBufferString(cfg))
+		if err == nil || err.Error() != want {
+			t.Errorf("%q: %v, want %q", cfg, err, want)
+		}
+	}
+}
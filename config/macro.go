@@ -0,0 +1,159 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const macroDef = "def"
+
+var errNoDefEnd = errors.New("def without end")
+
+// macro is a named list of entries added with the arguments of a call
+// as variables.
+type macro struct {
+	name   string
+	params []string
+	file   string
+	body   []stmt
+}
+
+// macroError is an error of an entry of a macro body.
+type macroError struct {
+	name string
+	file string
+	line int
+	err  error
+}
+
+func (e macroError) Error() string {
+	if e.file == "" {
+		return fmt.Sprintf("in macro %s, line %d: %v", e.name, e.line, e.err)
+	}
+	return fmt.Sprintf("in macro %s, %s:%d: %v", e.name, e.file, e.line, e.err)
+}
+
+// reserved reports whether name is an entry type or keyword.
+func reserved(name string) bool {
+	switch name {
+	case
+		TypeOmit, TypeAuto, TypeAutoRel, TypeDirectory, TypeRecursive,
+		TypeRecursiveRel, TypeRegular, TypeRegularRel, TypeGlob,
+		TypeGlobRel, TypeSymlink, TypeCreate, TypeCreateNoEndl,
+		TypeLinked, TypeLinkedGlob, TypeLinkedAbs, TypeLibrary,
+		TypePath, TypeBase64, TypeArchive, TypeVariable, TypeInclude,
+		maskMode, maskClear, maskIgnore, maskIgnoreNeg, maskReplace,
+		maskTime, maskLibrary,
+		condIf, condElse, condEnd, loopFor, loopList, macroDef:
+		return true
+	}
+	return false
+}
+
+func validName(s string) bool {
+	for k := 0; k < len(s); k++ {
+		if !isname(s[k], k == 0) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// def starts reading the body of a macro definition:
+//
+//	def name params...
+func (m *Map) def(r *reader, f entry, n int) error {
+	if len(f) < 2 {
+		return errNoArguments
+	}
+	if !validName(f[1]) || reserved(f[1]) {
+		return fmt.Errorf("invalid macro name: %s", f[1])
+	}
+	for _, v := range f[2:] {
+		if !validName(v) {
+			return fmt.Errorf("invalid macro parameter: %s", v)
+		}
+	}
+	r.loop = &loop{line: n, def: &macro{
+		name:   f[1],
+		params: append([]string(nil), f[2:]...),
+		file:   m.file,
+	}}
+	return nil
+}
+
+// call adds the body of mac with its parameters set to the arguments
+// of f, the variables are restored afterwards.
+func (m *Map) call(mac *macro, f entry) error {
+	args := f[1:]
+	if len(args) != len(mac.params) {
+		return fmt.Errorf("macro %s: %d arguments, want %d",
+			mac.name, len(args), len(mac.params))
+	}
+	for _, v := range m.calls {
+		if v == mac.name {
+			return fmt.Errorf("recursive macro: %s -> %s",
+				strings.Join(m.calls, " -> "), mac.name)
+		}
+	}
+
+	type saved struct {
+		v  variable
+		ok bool
+	}
+	prev := make([]saved, len(mac.params))
+	for k, v := range mac.params {
+		x, err := m.v.expand(args[k])
+		if err != nil {
+			return err
+		}
+		prev[k].v, prev[k].ok = m.v.m[v]
+		m.v.m[v] = variable{value: x}
+	}
+
+	// entries of the body are positioned in the file of the macro.
+	file, line := m.file, m.line
+	m.file = mac.file
+	m.calls = append(m.calls, mac.name)
+	defer func() {
+		m.file, m.line = file, line
+		m.calls = m.calls[:len(m.calls)-1]
+		for k, v := range mac.params {
+			if prev[k].ok {
+				m.v.m[v] = prev[k].v
+			} else {
+				delete(m.v.m, v)
+			}
+		}
+	}()
+
+	var (
+		r    reader
+		errs errorList
+	)
+	for _, s := range mac.body {
+		err := m.entry(&r, append(entry(nil), s.f...), s.n)
+		if err == nil {
+			continue
+		}
+		var l errorList
+		l.add(s.n, err)
+		for _, v := range l {
+			x := v.(lineError)
+			errs = append(errs, macroError{mac.name, mac.file, x.line, x.err})
+		}
+		if !m.c.Opt.KeepGoing {
+			return errs
+		}
+	}
+	if err := r.close(); err != nil {
+		x := err.(lineError)
+		errs = append(errs, macroError{mac.name, mac.file, x.line, x.err})
+	}
+
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
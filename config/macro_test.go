@@ -0,0 +1,120 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestMacro(t *testing.T) {
+	var c Config
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString(`
+$ name outer
+def daemon name conf
+	mm - ^etc/$name 0600
+	c etc/$name/$conf - - - <<EOF
+$name
+EOF
+	l ../../usr/bin/$name etc/$name/bin
+	if eq $conf none
+		d none
+	end
+	mc
+end
+
+def two a b
+	daemon $a $b
+	d ${a}-$b
+end
+
+daemon sshd sshd_config
+two crond none
+d $name
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r []string
+	for _, v := range m.A {
+		r = append(r, v.Dst)
+	}
+	want := []string{
+		"etc/sshd/sshd_config", "etc/sshd/bin",
+		"etc/crond/none", "etc/crond/bin", "none", "crond-none",
+		"outer",
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("entries %q, want %q", r, want)
+	}
+	if e := m.A[0]; e.Mode != 0600 || string(e.Data) != "sshd\n" || e.Line != 7 {
+		t.Errorf("entry: %+v", e)
+	}
+	if e := m.A[4]; e.Mode != 0755 {
+		t.Errorf("mask: %+v", e)
+	}
+
+	for cfg, want := range map[string]string{
+		"def x a\n\td $a\n":                       "def without end, line 1",
+		"def d a\nend\n":                          "invalid macro name: d, line 1",
+		"def x 1\nend\n":                          "invalid macro parameter: 1, line 1",
+		"def x a\nend\nx\n":                       "macro x: 0 arguments, want 1, line 3",
+		"def x a\n\td $a\n\tbad\nend\n\nx 1\n":    "line 6: in macro x, line 3: no arguments",
+		"def x\n\tx\nend\nx\n":                    "line 4: in macro x, line 2: recursive macro: x -> x",
+		"def y\n\tbad\nend\ndef x\n\ty\nend\nx\n": "line 7: in macro x, line 5: in macro y, line 2: no arguments",
+	} {
+		_, err := c.FromReader(context.Background(), bytes.NewBufferString(cfg))
+		if err == nil || err.Error() != want {
+			t.Errorf("%q: %v, want %q", cfg, err, want)
+		}
+	}
+}
+
+func TestMacroPosition(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_macro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for k, v := range map[string]string{
+		"main.archive": `include lib.archive
+
+x a
+d b
+`,
+		"lib.archive": `def x name
+	d $name
+end
+`,
+	} {
+		if err := ioutil.WriteFile(path.Join(tmp, k), []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var c Config
+	m, err := c.FromFiles(context.Background(), path.Join(tmp, "main.archive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		file string
+		line int
+	}{
+		{"lib.archive", 2},
+		{"main.archive", 4},
+	}
+	if len(m.A) != len(want) {
+		t.Fatalf("entries: %+v", m.A)
+	}
+	for k, v := range want {
+		if e := m.A[k]; e.File != path.Join(tmp, v.file) || e.Line != v.line {
+			t.Errorf("entry %d: %s:%d, want %s:%d", k, e.File, e.Line, v.file, v.line)
+		}
+	}
+}
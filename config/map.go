@@ -1,7 +1,9 @@
 package config
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -14,6 +16,7 @@ import (
 	stdelf "debug/elf"
 
 	"github.com/tlahdekorpi/archivegen/elf"
+	"github.com/tlahdekorpi/archivegen/progress"
 )
 
 type PathVar []string
@@ -27,7 +30,8 @@ func (p *PathVar) Set(v string) error {
 	return nil
 }
 
-var Opt struct {
+// Options control how entries are resolved.
+type Options struct {
 	Warn struct {
 		EmptyGlob bool `desc:"Glob types don't return any matches"`
 		Replace   bool `desc:"Entry is replaced"`
@@ -45,7 +49,13 @@ var Opt struct {
 	File struct {
 		Expand bool `desc:"Resolve all symlinks for relative files"`
 	}
-	Path PathVar `desc:"Search path"`
+	Var struct {
+		Env    bool `desc:"Expand variables not defined in the configuration from the environment"`
+		Strict bool `desc:"Undefined variables are an error"`
+	}
+	Path      PathVar `desc:"Search path"`
+	KeepGoing bool    `desc:"Continue past failing entries and report every error" flag:"k"`
+	Lint      bool    `desc:"Report replaced entries, unmatched masks, unused variables and optional entries that exist"`
 }
 
 const (
@@ -68,17 +78,27 @@ const (
 	TypeLibrary      = "i"
 	TypePath         = "p"
 	TypeBase64       = "b64"
+	TypeArchive      = "A"
 	TypeVariable     = "$"
+	TypeInclude      = "include"
 )
 
 type variable struct {
 	value string
+	list  []string
 	flag  bool
 }
 
 type variableMap struct {
 	m map[string]variable
-	r *strings.Replacer
+
+	env    bool // undefined variables are read from the environment.
+	strict bool // undefined variables are an error.
+
+	root string // rootfs of functions.
+
+	// definitions of variables with Opt.Lint.
+	defs map[string]*lintVar
 }
 
 func newVariableMap(vars []string) *variableMap {
@@ -88,25 +108,15 @@ func newVariableMap(vars []string) *variableMap {
 
 	ret := &variableMap{
 		m: make(map[string]variable),
-		r: strings.NewReplacer(),
-	}
-
-	if vars == nil {
-		return ret
 	}
 
-	r := make([]string, 0)
 	for x := 0; x < len(vars); x += 2 {
 		ret.m[vars[x]] = variable{
 			flag:  true,
 			value: vars[x+1],
 		}
-
-		r = append(r, TypeVariable+vars[x], vars[x+1])
 	}
 
-	ret.r = strings.NewReplacer(r...)
-
 	return ret
 }
 
@@ -120,7 +130,11 @@ func (m *variableMap) add(e entry) error {
 		return nil
 	}
 
-	if len(e) > 2 {
+	if e[idxType] == loopList {
+		// list values are joined with commas for brace expansion.
+		l := append([]string{}, e[idxDst:]...)
+		m.m[e[idxSrc]] = variable{value: strings.Join(l, ","), list: l}
+	} else if len(e) > 2 {
 		m.m[e[idxSrc]] = variable{value: e[idxDst]}
 	} else {
 		// without dst assume empty string
@@ -128,12 +142,6 @@ func (m *variableMap) add(e entry) error {
 		m.m[e[idxSrc]] = variable{}
 	}
 
-	r := make([]string, 0, len(m.m))
-	for k, v := range m.m {
-		r = append(r, TypeVariable+k, v.value)
-	}
-	m.r = strings.NewReplacer(r...)
-
 	return nil
 }
 
@@ -156,56 +164,47 @@ type Map struct {
 	// lookup existance/index of entries.
 	m map[string]int
 
-	v *variableMap
-	r *elf.Resolver
+	c   *Config
+	ctx context.Context
+	v   *variableMap
+	r   *elf.Resolver
 
 	prefix string
 
+	// configuration file and line of the current entry.
+	file string
+	line int
+
+	// absolute paths of the files being read, outermost first.
+	stack []string
+
+	macros map[string]*macro
+	calls  []string // macros being expanded.
+
 	wg  sync.WaitGroup
 	mu  sync.Mutex
 	elf []*result
 }
 
-func (c *Config) newMap() *Map {
+func (c *Config) newMap(ctx context.Context) *Map {
+	v := newVariableMap(c.Vars)
+	v.env, v.strict, v.root = c.Opt.Var.Env, c.Opt.Var.Strict, c.Prefix
+	if c.Opt.Lint {
+		v.defs = make(map[string]*lintVar)
+	}
 	return &Map{
+		ctx:    ctx,
 		m:      make(map[string]int),
 		mm:     make(maskMap, 0),
 		A:      make([]Entry, 0),
-		v:      newVariableMap(c.Vars),
+		c:      c,
+		v:      v,
+		macros: make(map[string]*macro),
 		prefix: c.Prefix,
 		r:      c.Resolver,
 	}
 }
 
-func multi(s string, escape bool) []string {
-	i := strings.IndexByte(s, '{')
-	if i < 0 {
-		return []string{s}
-	}
-
-	// TODO: a better solution for regex and multi overlap
-	if escape && i > 0 && s[i-1] != '\\' {
-		return []string{s}
-	}
-
-	e := strings.LastIndexByte(s[i:], '}')
-	if e < 0 {
-		return []string{s}
-	}
-
-	a, b := s[:i], s[e+i+1:]
-	if escape {
-		a = a[:len(a)-1]
-	}
-
-	var r []string
-	for _, v := range strings.Split(s[i+1:i+e], ",") {
-		r = append(r, a+v+b)
-	}
-
-	return r
-}
-
 func alternate(s string) []string {
 	i := strings.IndexByte(s, '(')
 	if i < 0 {
@@ -247,10 +246,31 @@ func lookup(prefix string, t string, files ...string) (file string, err error) {
 }
 
 func (m *Map) add(e entry, fail bool, line int) error {
-	for k, _ := range e {
-		e[k] = m.v.r.Replace(e[k])
+	data, re := -1, regexpField(e.Type())
+	switch e.Type() {
+	case TypeCreate, TypeCreateNoEndl:
+		data = e.typeOffset(idxData)
+	}
+	for k, v := range e {
+		expand := m.v.expand
+		switch k {
+		case data:
+			expand = m.v.expandData
+		case re:
+			expand = m.v.expandRegexp
+		}
+		x, err := expand(v)
+		if err != nil {
+			return err
+		}
+		e[k] = x
 	}
+	return m.addFields(e, fail, line)
+}
 
+// addFields adds an entry with variables already replaced.
+func (m *Map) addFields(e entry, fail bool, line int) error {
+	m.line = line
 	var err error
 	switch e.Type() {
 	case
@@ -260,68 +280,99 @@ func (m *Map) add(e entry, fail bool, line int) error {
 		maskIgnore,
 		maskIgnoreNeg,
 		maskMode:
-		m.mm, err = m.mm.set(e)
+		f, err := maskFromEntry(e)
+		if err != nil {
+			return err
+		}
+		if l := m.c.linter(); l != nil {
+			f = l.mask(e, f, pos{m.file, line})
+		}
+		m.mm, err = m.mm.put(e, f)
 		return err
 	case maskClear:
 		m.mm, err = m.mm.del(e)
 		return err
-	case TypeVariable:
-		return m.v.add(e)
+	case TypeVariable, loopList:
+		if err := m.v.add(e); err != nil {
+			return err
+		}
+		if l := m.c.linter(); l != nil {
+			l.define(m.v, e[idxSrc], pos{m.file, line})
+		}
+		return nil
+	case TypeArchive:
+		return m.addArchive(e, fail)
+	case TypeInclude:
+		return m.include(e, fail)
 	}
 
-	idx := idxSrc
-
-	var mu []string
+	var glob bool
 	switch e.Type() {
 	case TypeGlob, TypeGlobRel, TypeLinkedGlob:
-		mu = multi(e[idx], true)
-	default:
-		mu = multi(e[idx], false)
+		glob = true
 	}
+	src := multi(e[idxSrc], glob)
 
-	var dst string
+	// expanded destinations are paired with the sources, a single
+	// destination is the directory of expanded sources.
+	var dst []string
 	if len(e) > idxDst && e[idxDst] != TypeOmit {
 		switch e.Type() {
 		case
 			TypeDirectory,
-			TypeGlob,
-			TypeGlobRel,
 			TypeCreate,
 			TypeCreateNoEndl,
 			TypeBase64:
-			break
-		case TypeSymlink:
-			if len(mu) == 1 {
-				idx = idxDst
-				mu = multi(e[idx], false)
-				break
-			}
-			fallthrough
 		default:
-			dst = e[idxDst]
+			dst = multi(e[idxDst], false)
 		}
 	}
 
-	if len(mu) > 1 {
-		for _, v := range mu {
-			if dst != "" {
-				_, x := path.Split(v)
-				e[idxDst] = path.Join(dst, x)
+	switch {
+	case len(dst) > 1 && len(src) > 1 && len(dst) != len(src):
+		return fmt.Errorf("%d sources, %d destinations", len(src), len(dst))
+	case len(dst) > 1:
+		for k, v := range dst {
+			e[idxDst] = v
+			e[idxSrc] = src[0]
+			if len(src) > 1 {
+				e[idxSrc] = src[k]
 			}
-
-			e[idx] = v
-
-			err := m.add(e, fail, line)
-			if err != nil {
+			if err := m.addEntry(e, fail, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	case len(src) > 1:
+		for _, v := range src {
+			switch e.Type() {
+			case TypeGlob, TypeGlobRel:
+			default:
+				if dst != nil {
+					_, x := path.Split(v)
+					e[idxDst] = path.Join(dst[0], x)
+				}
+			}
+			e[idxSrc] = v
+			if err := m.addEntry(e, fail, line); err != nil {
 				return err
 			}
 		}
-
 		return nil
 	}
 
+	e[idxSrc] = src[0]
+	if dst != nil {
+		e[idxDst] = dst[0]
+	}
+	return m.addEntry(e, fail, line)
+}
+
+// addEntry adds an entry with braces expanded.
+func (m *Map) addEntry(e entry, fail bool, line int) error {
 	E, err := e.Entry()
 	E.Line = line
+	E.File = m.file
 	if err != nil {
 		return err
 	}
@@ -343,6 +394,9 @@ func (m *Map) add(e entry, fail bool, line int) error {
 		if a == nil {
 			E.Src, err = lookup(m.prefix, e.Type(), E.Src)
 		}
+		if l := m.c.linter(); l != nil && (err == nil || errors.Is(err, os.ErrNotExist)) {
+			l.exists(pos{m.file, line}, err == nil)
+		}
 		if errors.Is(err, os.ErrNotExist) {
 			return nil
 		}
@@ -351,24 +405,53 @@ func (m *Map) add(e entry, fail bool, line int) error {
 		return err
 	}
 
-	if m.mm.apply(&E) {
+	uid, gid, mode := -1, -1, -1
+	switch e.Type() {
+	case
+		TypeAuto,
+		TypeAutoRel:
+		if mode, err = e.pMode(); err != nil {
+			return err
+		}
+		fallthrough
+	case
+		TypeRecursive,
+		TypeRecursiveRel,
+		TypeGlob,
+		TypeGlobRel:
+		if uid, err = e.pUser(); err != nil {
+			return err
+		}
+		if gid, err = e.pGroup(); err != nil {
+			return err
+		}
+	}
+	return m.addParsed(E, e.isSet(idxDst), uid, gid, mode)
+}
+
+// addParsed adds the entry E, dst reports whether its destination was
+// set. Entries expanding to several files are added with uid, gid and
+// mode, -1 keeps the attribute of the file.
+func (m *Map) addParsed(E Entry, dst bool, uid, gid, mode int) error {
+	if m.apply(&E) {
 		// ignored by mask
 		return nil
 	}
 
+	var err error
 	switch E.Type {
 	case TypeRegularRel:
 		E.Type = TypeRegular
 		fallthrough
 	case TypeAutoRel:
 		E.Src = path.Join(m.prefix, E.Src)
-		if !Opt.File.Expand {
+		if !m.c.Opt.File.Expand {
 			break
 		}
 		if E.Src, err = m.expand(E.Src); err != nil {
 			return err
 		}
-		if !e.isSet(idxDst) {
+		if !dst {
 			E.Dst = clean(strings.TrimPrefix(E.Src, m.prefix))
 		}
 	case
@@ -377,28 +460,6 @@ func (m *Map) add(e entry, fail bool, line int) error {
 		E.Src = path.Join(m.prefix, E.Src)
 	}
 
-	var uid, gid, mode int
-	switch E.Type {
-	case
-		TypeAuto,
-		TypeAutoRel:
-		if mode, err = e.pMode(); err != nil {
-			return err
-		}
-		fallthrough
-	case
-		TypeRecursive,
-		TypeRecursiveRel,
-		TypeGlob,
-		TypeGlobRel:
-		if uid, err = e.pUser(); err != nil {
-			return err
-		}
-		if gid, err = e.pGroup(); err != nil {
-			return err
-		}
-	}
-
 	switch E.Type {
 	case
 		TypeLinkedGlob:
@@ -427,24 +488,16 @@ func (m *Map) add(e entry, fail bool, line int) error {
 		return m.addAuto(E, uid, gid, mode)
 	}
 
-	if i, exists := m.m[E.Dst]; exists {
-		rlog(m.A[i], E)
-		m.A[i] = E
-		return nil
-	}
-
-	m.A = append(m.A, E)
-	m.m[E.Dst] = len(m.A) - 1
+	m.insert(E)
 	return nil
 }
 
-func (m *Map) Add(e Entry) {
-	if m.mm.apply(&e) {
-		return
-	}
-
+func (m *Map) set(e Entry) {
 	if i, exists := m.m[e.Dst]; exists {
-		rlog(m.A[i], e)
+		m.rlog(m.A[i], e)
+		if l := m.c.linter(); l != nil {
+			l.replace(m.A[i], e)
+		}
 		m.A[i] = e
 		return
 	}
@@ -453,8 +506,41 @@ func (m *Map) Add(e Entry) {
 	m.m[e.Dst] = len(m.A) - 1
 }
 
-func rlog(e1, e2 Entry) {
-	if !Opt.Warn.Replace {
+func (m *Map) insert(e Entry) {
+	if e.Line == 0 {
+		e.Line = m.line
+	}
+	if e.File == "" {
+		e.File = m.file
+	}
+	if l := m.c.linter(); l != nil {
+		l.insert(e)
+	}
+	m.set(e)
+	progress.Report(m.ctx, progress.Event{
+		Stage:   progress.Resolve,
+		Entries: len(m.A),
+		File:    e.Dst,
+	})
+}
+
+// apply reports whether e is ignored by the current masks.
+func (m *Map) apply(e *Entry) bool {
+	if l := m.c.linter(); l != nil {
+		return l.apply(m.mm, e)
+	}
+	return m.mm.apply(e)
+}
+
+func (m *Map) Add(e Entry) {
+	if m.apply(&e) {
+		return
+	}
+	m.insert(e)
+}
+
+func (m *Map) rlog(e1, e2 Entry) {
+	if !m.c.Opt.Warn.Replace {
 		return
 	}
 	if e1.Src == e2.Src {
@@ -463,23 +549,13 @@ func rlog(e1, e2 Entry) {
 	log.Printf("replace: %s -> %s", e1.Src, e2.Src)
 }
 
-var q chan struct{}
-
 func (m *Map) resolve(e Entry, src string) {
-	if q == nil {
-		if Opt.ELF.NumGoroutine < 1 {
-			Opt.ELF.NumGoroutine = 1
-		}
-		q = make(chan struct{}, Opt.ELF.NumGoroutine)
-	}
+	q := m.c.queue()
 
 	mm := make(maskMap, len(m.mm))
 	copy(mm, m.mm)
 
-	m.wg.Add(1)
-	q <- struct{}{}
-	go func() {
-		r, err := m.r.Resolve(src, e.LibraryPath...)
+	add := func(r []string, err error) {
 		m.mu.Lock()
 		m.elf = append(m.elf, &result{
 			mm:   mm,
@@ -489,8 +565,22 @@ func (m *Map) resolve(e Entry, src string) {
 			err:  err,
 		})
 		m.mu.Unlock()
-		m.wg.Done()
+	}
+
+	// acquired before starting the goroutine to bound their number.
+	select {
+	case q <- struct{}{}:
+	case <-m.ctx.Done():
+		add(nil, m.ctx.Err())
+		return
+	}
+
+	m.wg.Add(1)
+	go func() {
+		r, err := m.r.Resolve(m.ctx, src, e.LibraryPath...)
 		<-q
+		add(r, err)
+		m.wg.Done()
 	}()
 }
 
@@ -507,15 +597,16 @@ func (m *Map) includeElfs() error {
 	m.wg.Wait()
 
 	var r multiError
-	mm := m.mm
+	mm, file := m.mm, m.file
 	for _, v := range m.elf {
 		m.mm = v.mm
+		m.file, m.line = v.e.File, v.e.Line
 		if err := m.includeElf(v); err != nil {
 			r = append(r, lineError{v.e.Line, err}.Error())
 		}
 	}
 
-	m.mm = mm
+	m.mm, m.file = mm, file
 	if len(r) == 0 {
 		return nil
 	}
@@ -550,7 +641,7 @@ func (m *Map) includeElf(r *result) error {
 	})
 
 	if r.err != nil {
-		if Opt.ELF.Fallback {
+		if m.c.Opt.ELF.Fallback {
 			return nil
 		} else {
 			return r.err
@@ -581,8 +672,6 @@ func (m *Map) includeElf(r *result) error {
 	return nil
 }
 
-var elfAdded = make(map[string]struct{})
-
 func (m *Map) addElf(e Entry) error {
 	var src string
 	if e.Type != TypeLinkedAbs {
@@ -591,26 +680,23 @@ func (m *Map) addElf(e Entry) error {
 		src = e.Src
 	}
 
-	if Opt.ELF.Once {
-		if _, ok := elfAdded[src]; ok {
-			return nil
-		}
-		elfAdded[src] = struct{}{}
+	if m.c.Opt.ELF.Once && !m.c.once(src) {
+		return nil
 	}
 
 	var err error
-	if Opt.ELF.Expand {
+	if m.c.Opt.ELF.Expand {
 		if src, err = m.expand(src); err != nil {
 			return err
 		}
 	}
 
-	if Opt.ELF.Concurrent {
+	if m.c.Opt.ELF.Concurrent {
 		m.resolve(e, src)
 		return nil
 	}
 
-	r, err := m.r.Resolve(src, e.LibraryPath...)
+	r, err := m.r.Resolve(m.ctx, src, e.LibraryPath...)
 	return m.includeElf(&result{
 		libs: r,
 		e:    e,
@@ -622,7 +708,9 @@ func (m *Map) addElf(e Entry) error {
 
 func (m *Map) Merge(t *Map) error {
 	for _, v := range t.A {
-		m.Add(v)
+		if !m.apply(&v) {
+			m.set(v)
+		}
 	}
 	return nil
 }
@@ -728,14 +816,14 @@ func (m *Map) addGlob(e Entry, uid, gid int) error {
 		return err
 	}
 
-	if Opt.Warn.EmptyGlob && len(r) < 1 {
+	if m.c.Opt.Warn.EmptyGlob && len(r) < 1 {
 		log.Printf("emptyglob: %s", e.Src)
 		return nil
 	}
 
 	for _, v := range r {
 		var src string
-		if Opt.Glob.Expand && e.Type == TypeGlobRel {
+		if m.c.Opt.Glob.Expand && e.Type == TypeGlobRel {
 			src, err = m.expand(v)
 			if err != nil {
 				return err
@@ -768,7 +856,7 @@ func (m *Map) addElfGlob(e Entry) error {
 		return err
 	}
 
-	if Opt.Warn.EmptyGlob && len(r) < 1 {
+	if m.c.Opt.Warn.EmptyGlob && len(r) < 1 {
 		log.Printf("emptyglob: %s", e.Src)
 		return nil
 	}
@@ -804,7 +892,7 @@ func (m *Map) addPath(e Entry) error {
 		return m.addElf(e)
 	}
 
-	for _, v := range Opt.Path {
+	for _, v := range m.c.Opt.Path {
 		file = path.Join(v, e.Src)
 		_, err = os.Lstat(path.Join(m.prefix, file))
 		if err == nil {
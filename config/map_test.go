@@ -2,6 +2,7 @@ package config
 
 import (
 	"bytes"
+	"context"
 	"testing"
 	"unsafe"
 )
@@ -115,34 +116,34 @@ var testMap = Map{
 		return r
 	}(),
 	A: []Entry{
-		{"name", "name", 0, 0, 0, TypeDirectory, "", 0, 0, nil, nil},
-		{"disk", "archive", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil},
-		{"dst", "dst", 0, 0, 0644, TypeCreate, "", 0, 0, []byte("test		  test  \n"), nil},
-		{"nodata", "nodata", 0, 0, 0644, TypeCreate, "", 0, 0, []byte{}, nil},
-		{"busybox", "sh", 0, 0, 0777, TypeSymlink, "", 0, 0, nil, nil},
-		{"omit_test1", "omit_test1", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil},
-		{"omit_test2", "omit_test2", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil},
-		{"merge1", "merge1", 0, 0, 0755, TypeDirectory, "", 0, 0, nil, nil},
-		{"merge2", "test", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil},
-		{"testvar1", "testvar1", 0, 0, 0755, TypeDirectory, "", 0, 0, nil, nil},
-		{"testvar2", "testvar2", 0, 0, 0755, TypeDirectory, "", 0, 0, nil, nil},
-		{"$testvar1", "$testvar1", 0, 0, 0755, TypeDirectory, "", 0, 0, nil, nil},
-		{"global1", "global1", 0, 0, 0755, TypeDirectory, "", 0, 0, nil, nil},
-		{"global2", "global2", 0, 0, 0755, TypeDirectory, "", 0, 0, nil, nil},
-		{"busybox", "foo", 0, 0, 0777, TypeSymlink, "", 0, 0, nil, nil},
-		{"busybox", "bar", 0, 0, 0777, TypeSymlink, "", 0, 0, nil, nil},
-		{"busybox", "baz", 0, 0, 0777, TypeSymlink, "", 0, 0, nil, nil},
-		{"multi1", "multi1", 1, 2, 0755, TypeDirectory, "", 0, 0, nil, nil},
-		{"multi2", "multi2", 1, 2, 0755, TypeDirectory, "", 0, 0, nil, nil},
-		{"multi3", "multi3", 1, 2, 0755, TypeDirectory, "", 0, 0, nil, nil},
-		{"../foo/bar", "symlinksrc/bar", 0, 0, 0777, TypeSymlink, "", 0, 0, nil, nil},
-		{"../foo/baz", "symlinksrc/baz", 0, 0, 0777, TypeSymlink, "", 0, 0, nil, nil},
-		{"multifile1", "multidst/multifile1", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil},
-		{"multifile2", "multidst/multifile2", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil},
-		{"multifile3", "multidst/multifile3", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil},
-		{"heredoc", "heredoc", 0, 0, 0644, TypeCreate, "!heredoc", 0, 0, []byte("test\\  data\n\n"), nil},
-		{"foo bar", "b az", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil},
-		{"base64", "base64", 0, 0, 0644, TypeBase64, "", 0, 0, []byte("YmFzZTY0"), nil},
+		{"name", "name", 0, 0, 0, TypeDirectory, "", 0, 0, nil, nil, ""},
+		{"disk", "archive", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil, ""},
+		{"dst", "dst", 0, 0, 0644, TypeCreate, "", 0, 0, []byte("test		  test  \n"), nil, ""},
+		{"nodata", "nodata", 0, 0, 0644, TypeCreate, "", 0, 0, []byte{}, nil, ""},
+		{"busybox", "sh", 0, 0, 0777, TypeSymlink, "", 0, 0, nil, nil, ""},
+		{"omit_test1", "omit_test1", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil, ""},
+		{"omit_test2", "omit_test2", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil, ""},
+		{"merge1", "merge1", 0, 0, 0755, TypeDirectory, "", 0, 0, nil, nil, ""},
+		{"merge2", "test", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil, ""},
+		{"testvar1", "testvar1", 0, 0, 0755, TypeDirectory, "", 0, 0, nil, nil, ""},
+		{"testvar2", "testvar2", 0, 0, 0755, TypeDirectory, "", 0, 0, nil, nil, ""},
+		{"$testvar1", "$testvar1", 0, 0, 0755, TypeDirectory, "", 0, 0, nil, nil, ""},
+		{"global1", "global1", 0, 0, 0755, TypeDirectory, "", 0, 0, nil, nil, ""},
+		{"global2", "global2", 0, 0, 0755, TypeDirectory, "", 0, 0, nil, nil, ""},
+		{"busybox", "foo", 0, 0, 0777, TypeSymlink, "", 0, 0, nil, nil, ""},
+		{"busybox", "bar", 0, 0, 0777, TypeSymlink, "", 0, 0, nil, nil, ""},
+		{"busybox", "baz", 0, 0, 0777, TypeSymlink, "", 0, 0, nil, nil, ""},
+		{"multi1", "multi1", 1, 2, 0755, TypeDirectory, "", 0, 0, nil, nil, ""},
+		{"multi2", "multi2", 1, 2, 0755, TypeDirectory, "", 0, 0, nil, nil, ""},
+		{"multi3", "multi3", 1, 2, 0755, TypeDirectory, "", 0, 0, nil, nil, ""},
+		{"../foo/bar", "symlinksrc/bar", 0, 0, 0777, TypeSymlink, "", 0, 0, nil, nil, ""},
+		{"../foo/baz", "symlinksrc/baz", 0, 0, 0777, TypeSymlink, "", 0, 0, nil, nil, ""},
+		{"multifile1", "multidst/multifile1", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil, ""},
+		{"multifile2", "multidst/multifile2", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil, ""},
+		{"multifile3", "multidst/multifile3", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil, ""},
+		{"heredoc", "heredoc", 0, 0, 0644, TypeCreate, "!heredoc", 0, 0, []byte("test\\  data\n\n"), nil, ""},
+		{"foo bar", "b az", 0, 0, 0644, TypeRegular, "", 0, 0, nil, nil, ""},
+		{"base64", "base64", 0, 0, 0644, TypeBase64, "", 0, 0, []byte("YmFzZTY0"), nil, ""},
 	},
 
 	// TODO: include elf
@@ -180,10 +181,10 @@ func TestMapResolve(t *testing.T) {
 
 	var m1, m2 *Map
 	var err error
-	if m1, err = c.FromReader(dataBuf1()); err != nil {
+	if m1, err = c.FromReader(context.Background(), dataBuf1()); err != nil {
 		t.Fatal(err)
 	}
-	if m2, err = c.FromReader(dataBuf2()); err != nil {
+	if m2, err = c.FromReader(context.Background(), dataBuf2()); err != nil {
 		t.Fatal(err)
 	}
 
@@ -259,3 +260,55 @@ func TestDst(t *testing.T) {
 		}
 	}
 }
+
+func TestConfigIsolation(t *testing.T) {
+	var a, b Config
+	a.Opt.ELF.NumGoroutine = 2
+
+	if !a.once("x") || a.once("x") {
+		t.Error("once: first add not reported")
+	}
+	if !b.once("x") {
+		t.Error("once: state shared between configs")
+	}
+	if n := cap(a.queue()); n != 2 {
+		t.Errorf("queue: %d, want 2", n)
+	}
+	if n := cap(b.queue()); n != 1 {
+		t.Errorf("queue: %d, want 1", n)
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	cfg := `d a
+bad
+c b - - - data
+zz c d
+f e
+`
+	var c Config
+	if _, err := c.FromReader(context.Background(), bytes.NewBufferString(cfg)); err == nil {
+		t.Fatal("no error")
+	}
+
+	c.Opt.KeepGoing = true
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString(cfg))
+	r, ok := err.(multiError)
+	if !ok || len(r) != 2 {
+		t.Fatalf("errors: %v", err)
+	}
+	for k, v := range []string{
+		"no arguments, line 2",
+		"invalid entry, line 4",
+	} {
+		if r[k] != v {
+			t.Errorf("error %d: %q, want %q", k, r[k], v)
+		}
+	}
+	if m == nil || len(m.A) != 3 {
+		t.Fatalf("map: %+v", m)
+	}
+	if m.A[2].Dst != "e" || m.A[2].Line != 5 {
+		t.Errorf("entry: %+v", m.A[2])
+	}
+}
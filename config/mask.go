@@ -49,7 +49,12 @@ func (m maskMap) set(e entry) (maskMap, error) {
 	if err != nil {
 		return nil, err
 	}
+	return m.put(e, f)
+}
 
+// put sets the mask f of e.
+func (m maskMap) put(e entry, f maskFunc) (maskMap, error) {
+	var err error
 	if e[idxMaskID] == TypeOmit {
 		return append(m, f), nil
 	}
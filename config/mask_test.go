@@ -1,6 +1,10 @@
 package config
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"testing"
+)
 
 func TestMaskMap(t *testing.T) {
 	var err error
@@ -121,3 +125,21 @@ func TestMaskMode(t *testing.T) {
 		}
 	}
 }
+
+func TestMaskRegexp(t *testing.T) {
+	var c Config
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString(`
+$ dir a
+mm 0 ^$dir$(b)? 0600
+d a
+d b
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range m.A {
+		if (v.Mode == 0600) != (v.Dst == "a") {
+			t.Errorf("%s: mode %o", v.Dst, v.Mode)
+		}
+	}
+}
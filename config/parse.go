@@ -0,0 +1,258 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Pos is a position in a configuration file, lines and columns start
+// at 1 and columns count bytes.
+type Pos struct {
+	Line, Col int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// Token is a field of an entry or a comment.
+type Token struct {
+	Pos  Pos
+	Text string
+}
+
+// Brace is a field continued on the following lines up to a line
+// starting with }, every line is an item of the brace list.
+//
+//	l {
+//		a
+//		b
+//	} dst
+type Brace struct {
+	Field int // index of the field ending with {.
+	Items []Token
+	Close Token // the field starting with }.
+}
+
+// Heredoc is the data of a c entry read from the following lines.
+type Heredoc struct {
+	Pos   Pos // first line of the data.
+	Delim string
+	Body  string
+}
+
+// Node is an entry of a configuration file, entries with a brace or
+// heredoc span several lines.
+type Node struct {
+	Fields  []Token
+	Brace   *Brace
+	Heredoc *Heredoc
+	End     int // last line.
+}
+
+// File is a parsed configuration file.
+type File struct {
+	Nodes    []*Node
+	Comments []Token
+}
+
+// Pos returns the position of the first field.
+func (n *Node) Pos() Pos {
+	return n.Fields[0].Pos
+}
+
+// entry returns the fields of n with the brace list and heredoc joined
+// into their fields.
+func (n *Node) entry() entry {
+	e := make(entry, len(n.Fields))
+	for k, v := range n.Fields {
+		e[k] = v.Text
+	}
+	if b := n.Brace; b != nil {
+		x := make([]string, len(b.Items))
+		for k, v := range b.Items {
+			x[k] = v.Text
+		}
+		e[b.Field] = multiReplace(e[b.Field] + strings.Join(x, "\n") + b.Close.Text)
+	}
+	if h := n.Heredoc; h != nil {
+		e[idxData-1] = h.Body
+		e = append(e, h.Delim)
+	}
+	return e
+}
+
+var multiReplace = strings.NewReplacer(
+	"\t", "",
+	"\n", ",",
+).Replace
+
+// split reports field separators, spaces escaped with a backslash
+// and whitespace inside $() are part of a field. In plain fields $(
+// is not special, regular expressions use $ as an end anchor.
+type split struct {
+	prev  rune
+	depth int
+	plain bool
+}
+
+func (s *split) split(r rune) bool {
+	switch {
+	case s.plain:
+	case s.prev == '$' && r == '(':
+		s.depth++
+	case r == ')' && s.depth > 0:
+		s.depth--
+	}
+	ret := s.depth == 0 && (s.prev != '\\' && r == ' ' || r == '\t')
+	s.prev = r
+	return ret
+}
+
+// lex splits the line s starting at pos into at most n fields, the
+// last field is the rest of the line. With n < 0 all fields are returned.
+func lex(s string, pos Pos, n int) []Token {
+	var (
+		r     []Token
+		sp    split
+		start = -1
+		plain = -1
+	)
+	for k, v := range s {
+		sep := sp.split(v)
+		switch {
+		case !sep && start < 0:
+			if n > 0 && len(r) == n-1 {
+				return append(r, Token{Pos{pos.Line, pos.Col + k}, s[k:]})
+			}
+			start = k
+			sp.plain = len(r) == plain
+		case sep && start >= 0:
+			r = append(r, Token{Pos{pos.Line, pos.Col + start}, s[start:k]})
+			start = -1
+			if len(r) == 1 {
+				plain = regexpField(r[0].Text)
+			}
+		}
+	}
+	if start >= 0 {
+		r = append(r, Token{Pos{pos.Line, pos.Col + start}, s[start:]})
+	}
+	return r
+}
+
+// indent returns s without leading spaces and tabs and the column of
+// its first character.
+func indent(s string) (string, int) {
+	d := strings.TrimLeft(s, " \t")
+	return d, len(s) - len(d) + 1
+}
+
+func isheredoc(f []Token) string {
+	if len(f) < idxData {
+		return ""
+	}
+	if x := f[idxData-1].Text; len(x) > 2 && x[:2] == "<<" {
+		return x[2:]
+	}
+	return ""
+}
+
+type parser struct {
+	s *bufio.Scanner
+	n int
+	f File
+}
+
+func (p *parser) scan() bool {
+	if !p.s.Scan() {
+		return false
+	}
+	p.n++
+	return true
+}
+
+// Parse reads the entries and comments of a configuration. Entries can
+// be indented, heredocs and brace lists without an end continue to the
+// end of the input. On error the entries read before are returned.
+func Parse(r io.Reader) (*File, error) {
+	p := &parser{s: bufio.NewScanner(r)}
+	for p.scan() {
+		d, col := indent(p.s.Text())
+		if len(d) < 1 {
+			continue
+		}
+		pos := Pos{p.n, col}
+		if d[0] == '#' {
+			p.f.Comments = append(p.f.Comments, Token{pos, d})
+			continue
+		}
+
+		n := new(Node)
+		t := d
+		if i := strings.IndexAny(d, " \t"); i >= 0 {
+			t = d[:i]
+		}
+		switch t {
+		case TypeCreate, TypeCreateNoEndl:
+			n.Fields = lex(d, pos, idxData)
+			if eof := isheredoc(n.Fields); eof != "" {
+				n.Heredoc = p.heredoc(eof)
+			}
+			if x := len(n.Fields); x < idxData {
+				n.Fields[x-1].Text = strings.TrimSpace(n.Fields[x-1].Text)
+			}
+		default:
+			n.Fields = lex(d, pos, -1)
+			if d[len(d)-1] == '{' {
+				p.brace(n)
+			}
+		}
+		n.End = p.n
+		p.f.Nodes = append(p.f.Nodes, n)
+	}
+	if err := p.s.Err(); err != nil {
+		return &p.f, lineError{p.n + 1, err}
+	}
+	return &p.f, nil
+}
+
+func (p *parser) heredoc(eof string) *Heredoc {
+	h := &Heredoc{Pos: Pos{p.n + 1, 1}, Delim: eof}
+	var b []string
+	for p.scan() {
+		if x := p.s.Text(); x != eof {
+			b = append(b, x)
+			continue
+		}
+		break
+	}
+	h.Body = strings.Join(b, "\n")
+	return h
+}
+
+func (p *parser) brace(n *Node) {
+	b := &Brace{Field: len(n.Fields) - 1}
+	n.Brace = b
+	for p.scan() {
+		t := p.s.Text()
+		d := strings.TrimSpace(t)
+		if len(d) == 0 {
+			continue
+		}
+		pos := Pos{p.n, strings.Index(t, d) + 1}
+		switch d[0] {
+		case '#':
+			p.f.Comments = append(p.f.Comments, Token{pos, d})
+		case '}':
+			f := lex(d, pos, -1)
+			b.Close = f[0]
+			n.Fields = append(n.Fields, f[1:]...)
+			return
+		default:
+			b.Items = append(b.Items, Token{pos, d})
+		}
+	}
+}
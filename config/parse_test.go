@@ -0,0 +1,80 @@
+package config
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	f, err := Parse(bytes.NewBufferString(`# comment
+f /a\ b  dst 0600
+  d $(basename $x)
+
+c file - - - <<EOF
+  data
+EOF
+c line - - - some data
+	l {
+		a
+		# item comment
+		b
+	} links 0777
+cat a b c d e f g
+mr 0 a[$(] b
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []Token{{Pos{1, 1}, "# comment"}, {Pos{11, 3}, "# item comment"}}; !reflect.DeepEqual(f.Comments, want) {
+		t.Errorf("comments: %v, want %v", f.Comments, want)
+	}
+
+	want := []struct {
+		pos   []Pos
+		end   int
+		entry entry
+	}{
+		{[]Pos{{2, 1}, {2, 3}, {2, 10}, {2, 14}}, 2, entry{"f", `/a\ b`, "dst", "0600"}},
+		{[]Pos{{3, 3}, {3, 5}}, 3, entry{"d", "$(basename $x)"}},
+		{[]Pos{{5, 1}, {5, 3}, {5, 8}, {5, 10}, {5, 12}, {5, 14}}, 7, entry{"c", "file", "-", "-", "-", "  data", "EOF"}},
+		{[]Pos{{8, 1}, {8, 3}, {8, 8}, {8, 10}, {8, 12}, {8, 14}}, 8, entry{"c", "line", "-", "-", "-", "some data"}},
+		{[]Pos{{9, 2}, {9, 4}, {13, 4}, {13, 10}}, 13, entry{"l", "{a,b}", "links", "0777"}},
+		{[]Pos{{14, 1}, {14, 5}, {14, 7}, {14, 9}, {14, 11}, {14, 13}, {14, 15}, {14, 17}}, 14, entry{"cat", "a", "b", "c", "d", "e", "f", "g"}},
+		{[]Pos{{15, 1}, {15, 4}, {15, 6}, {15, 12}}, 15, entry{"mr", "0", "a[$(]", "b"}},
+	}
+	if len(f.Nodes) != len(want) {
+		t.Fatalf("nodes: %d, want %d", len(f.Nodes), len(want))
+	}
+	for k, v := range want {
+		n := f.Nodes[k]
+		var pos []Pos
+		for _, x := range n.Fields {
+			pos = append(pos, x.Pos)
+		}
+		if !reflect.DeepEqual(pos, v.pos) || n.End != v.end {
+			t.Errorf("node %d: %v end %d, want %v end %d", k, pos, n.End, v.pos, v.end)
+		}
+		if e := n.entry(); !reflect.DeepEqual(e, v.entry) {
+			t.Errorf("node %d: %q, want %q", k, e, v.entry)
+		}
+	}
+
+	if h := f.Nodes[2].Heredoc; h == nil || h.Pos != (Pos{6, 1}) || h.Delim != "EOF" {
+		t.Errorf("heredoc: %+v", h)
+	}
+	b := f.Nodes[4].Brace
+	if b == nil || b.Field != 1 || b.Close.Pos != (Pos{13, 2}) {
+		t.Fatalf("brace: %+v", b)
+	}
+	if want := []Token{{Pos{10, 3}, "a"}, {Pos{12, 3}, "b"}}; !reflect.DeepEqual(b.Items, want) {
+		t.Errorf("brace items: %v, want %v", b.Items, want)
+	}
+
+	if _, err := Parse(strings.NewReader("d a\n" + strings.Repeat("x", 1<<17))); err == nil ||
+		err.Error() != "bufio.Scanner: token too long, line 2" {
+		t.Errorf("long line: %v", err)
+	}
+}
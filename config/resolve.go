@@ -99,7 +99,7 @@ func (m *Map) readlink(s string, n int, c int) (string, error) {
 		0,
 		0777,
 		TypeSymlink,
-		"", 0, 0, nil, nil,
+		"", 0, 0, nil, nil, "",
 	})
 
 	if x := strings.IndexByte(r, '/'); x >= 0 {
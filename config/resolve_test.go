@@ -1,6 +1,7 @@
 package config
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path"
@@ -8,7 +9,7 @@ import (
 	"testing"
 )
 
-const nulstr = string(0)
+const nulstr = "\x00"
 
 func join(a, b string) string {
 	return a + "/" + b
@@ -138,7 +139,7 @@ func TestExpand(t *testing.T) {
 	dir := pwd(r)
 
 	var c Config
-	m := c.newMap()
+	m := c.newMap(context.Background())
 
 	for _, v := range expandTests {
 		v.v = strings.Replace(v.v, nulstr, tmp, -1)
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func isname(c byte, first bool) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+		!first && '0' <= c && c <= '9'
+}
+
+// lookup returns the value of the variable name.
+func (m *variableMap) lookup(name string) (string, bool) {
+	if v, ok := m.m[name]; ok {
+		m.use(name)
+		return v.value, true
+	}
+	if m.env && name != "" {
+		return os.LookupEnv(name)
+	}
+	return "", false
+}
+
+// prefix returns the longest variable name s starts with, the
+// environment is only used when no variable matches.
+func (m *variableMap) prefix(s string) (string, string, bool) {
+	var name string
+	for k := range m.m {
+		if len(k) > len(name) && strings.HasPrefix(s, k) {
+			name = k
+		}
+	}
+	if name != "" {
+		m.use(name)
+		return name, m.m[name].value, true
+	}
+	if !m.env {
+		return "", "", false
+	}
+	var n int
+	for n < len(s) && isname(s[n], n == 0) {
+		n++
+	}
+	for ; n > 0; n-- {
+		if v, ok := os.LookupEnv(s[:n]); ok {
+			return s[:n], v, true
+		}
+	}
+	return "", "", false
+}
+
+// use marks the definition of name used.
+func (m *variableMap) use(name string) {
+	if x, ok := m.defs[name]; ok {
+		x.used = true
+	}
+}
+
+// brace returns the index of the } closing the ${ at the start of s.
+func brace(s string) int {
+	var d int
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			d++
+			i++
+		case s[i] == '}':
+			if d--; d == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// expand replaces the variables of s:
+//
+//	$name             longest defined name, or left as is
+//	${name}           name, or left as is when undefined
+//	${name:-default}  name, or default when name is undefined or empty
+//	$(func args...)   result of a function, lists are joined with commas
+//	$$                $
+func (m *variableMap) expand(s string) (string, error) {
+	return m.replace(s, "")
+}
+
+// expandData replaces the $name variables of the data of a c entry,
+// $(...), ${...} and $$ are left as is: data is often a script.
+func (m *variableMap) expandData(s string) (string, error) {
+	return m.replace(s, "$({")
+}
+
+// expandRegexp replaces the variables of a regular expression, $( is
+// left as is: it is an end anchor followed by a group.
+func (m *variableMap) expandRegexp(s string) (string, error) {
+	return m.replace(s, "(")
+}
+
+// replace expands the variables of s, a $ followed by a byte in keep
+// is left as is.
+func (m *variableMap) replace(s string, keep string) (string, error) {
+	i := strings.IndexByte(s, '$')
+	if i < 0 {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for ; i >= 0; i = strings.IndexByte(s, '$') {
+		b.WriteString(s[:i])
+		s = s[i:]
+
+		switch {
+		case len(s) > 1 && strings.IndexByte(keep, s[1]) >= 0:
+			b.WriteString(s[:2])
+			s = s[2:]
+			continue
+
+		case len(s) > 1 && s[1] == '$':
+			b.WriteByte('$')
+			s = s[2:]
+			continue
+
+		case len(s) > 1 && s[1] == '(':
+			j := paren(s)
+			if j < 0 {
+				return "", fmt.Errorf("unterminated function: %s", s)
+			}
+			r, err := m.call(s[2:j])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(strings.Join(r, ","))
+			s = s[j+1:]
+			continue
+
+		case len(s) > 1 && s[1] == '{':
+			j := brace(s)
+			if j < 0 {
+				return "", fmt.Errorf("unterminated variable: %s", s)
+			}
+			v, err := m.braced(s[2:j])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+			s = s[j+1:]
+			continue
+		}
+
+		name, v, ok := m.prefix(s[1:])
+		if !ok {
+			if m.strict && len(s) > 1 && isname(s[1], true) {
+				var n int
+				for n+1 < len(s) && isname(s[n+1], n == 0) {
+					n++
+				}
+				return "", fmt.Errorf("undefined variable: %s", s[1:n+1])
+			}
+			b.WriteByte('$')
+			s = s[1:]
+			continue
+		}
+		b.WriteString(v)
+		s = s[1+len(name):]
+	}
+
+	b.WriteString(s)
+	return b.String(), nil
+}
+
+// braced returns the value of the contents of ${}.
+func (m *variableMap) braced(s string) (string, error) {
+	name, def := s, ""
+	i := strings.Index(s, ":-")
+	if i >= 0 {
+		name, def = s[:i], s[i+2:]
+	}
+	if name == "" {
+		return "", fmt.Errorf("invalid variable: ${%s}", s)
+	}
+
+	v, ok := m.lookup(name)
+	switch {
+	case i >= 0 && v == "":
+		return m.expand(def)
+	case !ok && m.strict:
+		return "", fmt.Errorf("undefined variable: %s", name)
+	case !ok:
+		return "${" + s + "}", nil
+	}
+	return v, nil
+}
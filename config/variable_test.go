@@ -0,0 +1,106 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+)
+
+func TestExpandVariables(t *testing.T) {
+	m := newVariableMap([]string{"a", "1", "ab", "2", "foo-bar", "3", "empty", ""})
+	for _, v := range []struct{ in, out string }{
+		{"$a $ab $abc", "1 2 2c"},
+		{"$a$ab", "12"},
+		{"${a}b", "1b"},
+		{"$foo-bar", "3"},
+		{"${undefined}", "${undefined}"},
+		{"/a/${undefined}/b", "/a/${undefined}/b"},
+		{"${undefined:-x}", "x"},
+		{"${empty:-$a}", "1"},
+		{"${undefined:-${ab}x}", "2x"},
+		{"${a:-x}", "1"},
+		{"$$a $$$a", "$a $1"},
+		{"$undefined .so$ $1", "$undefined .so$ $1"},
+		{"{$a,$ab}", "{1,2}"},
+	} {
+		r, err := m.expand(v.in)
+		if err != nil {
+			t.Errorf("%q: %v", v.in, err)
+		}
+		if r != v.out {
+			t.Errorf("%q: %q, want %q", v.in, r, v.out)
+		}
+	}
+
+	for _, v := range []string{"${a", "${}", "${:-x}"} {
+		if _, err := m.expand(v); err == nil {
+			t.Errorf("%q: no error", v)
+		}
+	}
+
+	m.strict = true
+	for in, want := range map[string]string{
+		"$undefined":   "undefined variable: undefined",
+		"${undefined}": "undefined variable: undefined",
+		"x$_u1-y":      "undefined variable: _u1",
+	} {
+		if _, err := m.expand(in); err == nil || err.Error() != want {
+			t.Errorf("%q: %v, want %s", in, err, want)
+		}
+	}
+	for _, v := range []string{"${undefined:-}", ".so$", "$1", "$$undefined"} {
+		if _, err := m.expand(v); err != nil {
+			t.Errorf("%q: %v", v, err)
+		}
+	}
+
+	m.strict = false
+	os.Setenv("ARCHIVEGEN_TEST", "env")
+	defer os.Unsetenv("ARCHIVEGEN_TEST")
+	if r, _ := m.expand("$ARCHIVEGEN_TEST"); r != "$ARCHIVEGEN_TEST" {
+		t.Errorf("environment without env: %q", r)
+	}
+	m.env = true
+	for in, want := range map[string]string{
+		"$ARCHIVEGEN_TEST":     "env",
+		"$ARCHIVEGEN_TEST_DIR": "env_DIR",
+	} {
+		if r, err := m.expand(in); err != nil || r != want {
+			t.Errorf("%q: %q %v, want %q", in, r, err, want)
+		}
+	}
+	m.m["ARCHIVEGEN"] = variable{value: "config"}
+	m.m["ARCHIVEGEN_TEST_DIR"] = variable{value: "dir"}
+	for in, want := range map[string]string{
+		"$ARCHIVEGEN_TEST":     "config_TEST",
+		"${ARCHIVEGEN_TEST}":   "env",
+		"$ARCHIVEGEN_TEST_DIR": "dir",
+		"$ARCHIVEGEN":          "config",
+		"$ARCHIVEGEN_":         "config_",
+	} {
+		if r, err := m.expand(in); err != nil || r != want {
+			t.Errorf("%q: %q %v, want %q", in, r, err, want)
+		}
+	}
+}
+
+func TestExpandData(t *testing.T) {
+	var c Config
+	m, err := c.FromReader(context.Background(), bytes.NewBufferString(`
+$ dir /etc
+c init 0755 - - <<EOF
+#!/bin/sh
+PATH=${PATH}:/sbin
+echo $$ > /run/init.pid
+ls $dir ${dir} ${HOME:-/root}
+EOF
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "#!/bin/sh\nPATH=${PATH}:/sbin\necho $$ > /run/init.pid\nls /etc ${dir} ${HOME:-/root}\n"
+	if len(m.A) != 1 || string(m.A[0].Data) != want {
+		t.Errorf("entries: %+v\nwant %q", m.A, want)
+	}
+}
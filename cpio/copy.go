@@ -0,0 +1,32 @@
+package cpio
+
+import (
+	"io"
+	"os"
+)
+
+func (cw *Writer) WriteFile(file *os.File, hdr *Header) error {
+	if err := cw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := cw.ReadFrom(file)
+	return err
+}
+
+// ReadFrom copies the remaining bytes of the current entry from r,
+// the underlying writer is used directly if it implements io.ReaderFrom.
+func (cw *Writer) ReadFrom(r io.Reader) (int64, error) {
+	var (
+		n   int64
+		err error
+		lr  = &io.LimitedReader{R: r, N: cw.remaining}
+	)
+	if rf, ok := cw.w.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(lr)
+	} else {
+		n, err = io.Copy(cw.w, lr)
+	}
+	cw.length += n
+	cw.remaining -= n
+	return n, err
+}
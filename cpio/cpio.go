@@ -47,6 +47,8 @@ type Header struct {
 	Devminor int    // minor number of character or block device.
 	Type     int    // filetype.
 	Name     string // name of header file entry.
+	Inode    int64  // inode number, assigned by Writer when zero.
+	Links    int    // number of links.
 }
 
 func (hdr *Header) filemode() int {
@@ -64,6 +66,12 @@ func NewWriter(w io.Writer) *Writer {
 	return &Writer{w: w, inode: 1}
 }
 
+// NewWriterOffset returns a Writer continuing an archive of offset bytes
+// without a trailer, inode is the last inode used in the archive.
+func NewWriterOffset(w io.Writer, offset, inode int64) *Writer {
+	return &Writer{w: w, inode: inode + 1, length: offset}
+}
+
 func fmt16(n int64) []byte {
 	r := []byte{'0', '0', '0', '0', '0', '0', '0', '0'}
 	i := len(r)
@@ -89,11 +97,11 @@ func newcHeader(u ...int64) []byte {
 
 func (cw *Writer) header(hdr *Header) []byte {
 	ret := newcHeader(
-		cw.inode,
+		hdr.Inode,
 		int64(hdr.filemode()),
 		int64(hdr.Uid),
 		int64(hdr.Gid),
-		0,
+		int64(hdr.Links),
 		hdr.Mtime,
 		hdr.Size,               // filesize
 		0,                      // devmajor
@@ -137,6 +145,11 @@ func (cw *Writer) WriteHeader(hdr *Header) error {
 		return err
 	}
 
+	if hdr.Inode == 0 {
+		hdr.Inode = cw.inode
+		cw.inode++
+	}
+
 	// write header bytes
 	b := cw.header(hdr)
 	n, err := cw.write(b)
@@ -147,8 +160,7 @@ func (cw *Writer) WriteHeader(hdr *Header) error {
 		return errPartialWrite
 	}
 
-	// bump inode, set remaining bytes for file
-	cw.inode++
+	// set remaining bytes for file
 	cw.remaining = hdr.Size
 
 	return cw.pad(4)
@@ -2,6 +2,7 @@ package cpio
 
 import (
 	"bytes"
+	"io"
 	"testing"
 )
 
@@ -112,3 +113,40 @@ func TestWriter(t *testing.T) {
 		t.Fatal("have != golden")
 	}
 }
+
+func TestReader(t *testing.T) {
+	entries := []struct {
+		header   Header
+		contents string
+	}{
+		{Header{Name: "dst", Uid: 1234, Gid: 4321, Size: 3, Mode: 0777, Type: TypeSymlink}, "src"},
+		{Header{Name: "regular", Uid: 1234, Gid: 4321, Size: 12, Mode: 0640, Type: TypeRegular}, "regular file"},
+		{Header{Name: "dir/", Uid: 1234, Gid: 4321, Size: 0, Mode: 0755, Type: TypeDir}, ""},
+	}
+
+	r := NewReader(bytes.NewReader(want[:]))
+	for k, v := range entries {
+		hdr, err := r.Next()
+		if err != nil {
+			t.Fatal(k, err)
+		}
+		v.header.Inode = int64(k + 1)
+		if *hdr != v.header {
+			t.Fatalf("%d: header\n%+v\n%+v", k, *hdr, v.header)
+		}
+		b := new(bytes.Buffer)
+		if _, err := b.ReadFrom(r); err != nil {
+			t.Fatal(k, err)
+		}
+		if s := b.String(); s != v.contents {
+			t.Fatalf("%d: contents %q != %q", k, s, v.contents)
+		}
+	}
+
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("trailer: %v", err)
+	}
+	if n := r.Offset(); n != 368 {
+		t.Fatalf("trailer offset: %d", n)
+	}
+}
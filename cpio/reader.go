@@ -0,0 +1,145 @@
+package cpio
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+var errInvalidHeader = errors.New("cpio: invalid header")
+
+const (
+	// cpio newc format magic with checksums
+	crcMagic = "070702"
+	// length of a newc header without the name
+	headerSize = 110
+	// name of the last entry in an archive
+	trailerName = "TRAILER!!!"
+)
+
+type Reader struct {
+	r         io.Reader
+	length    int64
+	remaining int64
+	offset    int64
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+func (cr *Reader) read(b []byte) error {
+	n, err := io.ReadFull(cr.r, b)
+	cr.length += int64(n)
+	return err
+}
+
+func (cr *Reader) skip(l int64) error {
+	n, err := io.CopyN(ioutil.Discard, cr.r, l)
+	cr.length += n
+	return err
+}
+
+// skips to the next multiple of mod
+func (cr *Reader) align(mod int64) error {
+	return cr.skip((mod - (cr.length % mod)) % mod)
+}
+
+func parse16(b []byte) (int64, error) {
+	n, err := strconv.ParseUint(string(b), 16, 32)
+	if err != nil {
+		return 0, errInvalidHeader
+	}
+	return int64(n), nil
+}
+
+// Next advances to the next entry in the archive, io.EOF is returned
+// at the trailer.
+func (cr *Reader) Next() (*Header, error) {
+	if err := cr.skip(cr.remaining); err != nil {
+		return nil, err
+	}
+	cr.remaining = 0
+
+	if err := cr.align(4); err != nil {
+		return nil, err
+	}
+	cr.offset = cr.length
+
+	var b [headerSize]byte
+	if err := cr.read(b[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errInvalidHeader
+		}
+		return nil, err
+	}
+
+	if m := string(b[:6]); m != newcMagic && m != crcMagic {
+		return nil, errInvalidHeader
+	}
+
+	var f [13]int64
+	for k := range f {
+		n, err := parse16(b[6+k*8 : 14+k*8])
+		if err != nil {
+			return nil, err
+		}
+		f[k] = n
+	}
+
+	if f[11] < 1 {
+		return nil, errInvalidHeader
+	}
+	name := make([]byte, f[11])
+	if err := cr.read(name); err != nil {
+		return nil, err
+	}
+	if err := cr.align(4); err != nil {
+		return nil, err
+	}
+
+	hdr := &Header{
+		Mode:     int(f[1] & 0xFFF),
+		Type:     int(f[1]>>12) & 0xF,
+		Uid:      int(f[2]),
+		Gid:      int(f[3]),
+		Mtime:    f[5],
+		Size:     f[6],
+		Devmajor: int(f[9]),
+		Devminor: int(f[10]),
+		Name:     string(name[:len(name)-1]),
+		Inode:    f[0],
+		Links:    int(f[4]),
+	}
+
+	if hdr.Name == trailerName {
+		return nil, io.EOF
+	}
+
+	cr.remaining = hdr.Size
+	return hdr, nil
+}
+
+// Offset returns the offset of the current header, after Next returns
+// io.EOF it is the offset of the trailer or the end of the archive.
+func (cr *Reader) Offset() int64 {
+	return cr.offset
+}
+
+// Read reads from the current entry.
+func (cr *Reader) Read(b []byte) (int, error) {
+	if cr.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > cr.remaining {
+		b = b[:cr.remaining]
+	}
+	n, err := cr.r.Read(b)
+	cr.length += int64(n)
+	cr.remaining -= int64(n)
+	if err == io.EOF && cr.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
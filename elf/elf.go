@@ -11,12 +11,18 @@ var (
 	errPartialRead = errors.New("elf: partial read")
 )
 
+// files are cached by name and prefix.
+type fskey struct {
+	name, prefix string
+}
+
 type fsfile struct {
-	name   string
+	key    fskey
 	interp string
 	class  elf.Class
 	elf    *elf.File
 	file   File
+	cache  *loader
 }
 
 func (f *fsfile) Dynamic() (File, error) {
@@ -40,9 +46,9 @@ func (f *fsfile) Dynamic() (File, error) {
 	}
 	f.elf = nil
 
-	mu.Lock()
-	cache[f.name] = f
-	mu.Unlock()
+	f.cache.mu.Lock()
+	f.cache.m[f.key] = f
+	f.cache.mu.Unlock()
 	return f.file, nil
 }
 
@@ -85,18 +91,31 @@ func (f *fsfile) Close() error {
 	return f.elf.Close()
 }
 
-var mu sync.Mutex
-var cache = make(map[string]*fsfile)
+// loader caches the dynamic section of files loaded by a Resolver.
+type loader struct {
+	mu sync.Mutex
+	m  map[fskey]*fsfile
+}
+
+// NewLoader returns the default Loader opening files from the
+// filesystem, each Loader has its own cache.
+func NewLoader() Loader {
+	l := &loader{m: make(map[fskey]*fsfile)}
+	return l.load
+}
+
+func (l *loader) load(file, prefix string) (ELF, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-func defaultLoader(file, prefix string) (ELF, error) {
-	mu.Lock()
-	defer mu.Unlock()
-	if f, exists := cache[file]; exists {
+	key := fskey{file, prefix}
+	if f, exists := l.m[key]; exists {
 		return f, nil
 	}
 
 	r := &fsfile{
-		name: file,
+		key:   key,
+		cache: l,
 	}
 
 	var err error
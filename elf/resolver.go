@@ -2,6 +2,7 @@ package elf
 
 import (
 	"bufio"
+	"context"
 	"debug/elf"
 	"os"
 	"path"
@@ -206,7 +207,9 @@ func (f *fileset) ok(dir, file string) bool {
 	return ok
 }
 
-type context struct {
+// state of a single Resolve.
+type state struct {
+	ctx    context.Context
 	err    set
 	cache  *fileset
 	ldconf pathset
@@ -215,7 +218,7 @@ type context struct {
 	loader Loader
 }
 
-func (c *context) search1(file string, ret set, from []pe) (string, ELF, error) {
+func (c *state) search1(file string, ret set, from []pe) (string, ELF, error) {
 	var r string
 
 	for _, v := range from {
@@ -277,7 +280,7 @@ func (c *context) search1(file string, ret set, from []pe) (string, ELF, error)
 	return r, nil, errorNotFound(r)
 }
 
-func (c *context) search(file string, ret set, path ...[]pe) (string, ELF, error) {
+func (c *state) search(file string, ret set, path ...[]pe) (string, ELF, error) {
 	var (
 		f   ELF
 		r   string
@@ -317,7 +320,10 @@ func mkpe(p []string) []pe {
 	return r
 }
 
-func (c *context) resolv(file string, f ELF, rpath pathset, runpath []pe, ret set) error {
+func (c *state) resolv(file string, f ELF, rpath pathset, runpath []pe, ret set) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
 	if ret.add(file) {
 		return nil
 	}
@@ -477,7 +483,7 @@ func NewResolver(prefix string) *Resolver {
 	return &Resolver{
 		cache:  newfileset(prefix),
 		prefix: prefix,
-		Loader: defaultLoader,
+		Loader: NewLoader(),
 	}
 }
 
@@ -487,27 +493,28 @@ func (r *Resolver) ReadConfig(file string) error {
 	return err
 }
 
-func (r *Resolver) Resolve(file string, ld ...string) ([]string, error) {
+func (r *Resolver) Resolve(ctx context.Context, file string, ld ...string) ([]string, error) {
 	f, err := r.Loader(file, r.prefix)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx := context{
+	s := state{
+		ctx:    ctx,
 		err:    make(set),
 		class:  f.Class(),
 		root:   r.prefix,
 		cache:  r.cache,
 		loader: r.Loader,
 	}
-	ctx.ldconf = ctx.ldconf.add(
+	s.ldconf = s.ldconf.add(
 		path.Dir(file),
 		append(ld, r.ldconf...)...,
 	)
 
 	r.mu.Lock()
 	if r.class == elf.ELFCLASSNONE {
-		r.class = ctx.class
+		r.class = s.class
 	}
 	r.mu.Unlock()
 
@@ -516,7 +523,7 @@ func (r *Resolver) Resolve(file string, ld ...string) ([]string, error) {
 		ret.add(path.Join(r.prefix, i))
 	}
 
-	if err := ctx.resolv(
+	if err := s.resolv(
 		file,
 		f,
 		make(pathset),
@@ -526,9 +533,9 @@ func (r *Resolver) Resolve(file string, ld ...string) ([]string, error) {
 		return nil, err
 	}
 
-	if len(ctx.err) > 0 {
+	if len(s.err) > 0 {
 		return nil, errorNotFound(
-			file + ": " + strings.Join(ctx.err.list(), ", "),
+			file + ": " + strings.Join(s.err.list(), ", "),
 		)
 	}
 
@@ -1,6 +1,7 @@
 package elf
 
 import (
+	"context"
 	"debug/elf"
 	"sort"
 	"testing"
@@ -41,7 +42,7 @@ func mapOpen(d map[string]ef) Loader {
 func testResolve(t *testing.T, f string, re []string, data map[string]ef) {
 	resolver := &Resolver{Loader: mapOpen(data)}
 
-	r, err := resolver.Resolve(f)
+	r, err := resolver.Resolve(context.Background(), f)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/tlahdekorpi/archivegen/config"
+)
+
+// formatFile returns the contents of r and its canonical format.
+func formatFile(r io.Reader, sort bool) ([]byte, []byte, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := config.Parse(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, err
+	}
+	var w bytes.Buffer
+	if err := f.Format(&w, sort); err != nil {
+		return nil, nil, err
+	}
+	return b, w.Bytes(), nil
+}
+
+// formatMain is the fmt mode, files are formatted to stdout, in place
+// with -w or listed with -check when they are not formatted.
+func formatMain(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	var (
+		check = fs.Bool("check", false, "List files that are not formatted and exit non-zero")
+		write = fs.Bool("w", false, "Write the result to the file instead of stdout")
+		sort  = fs.Bool("sort", false, "Sort brace lists of sources and destinations")
+	)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s %s\n", "archivegen fmt", "[OPTIONS...] [FILES...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	var status int
+	for _, v := range files {
+		var (
+			in, out []byte
+			err     error
+		)
+		if v == "-" {
+			in, out, err = formatFile(os.Stdin, *sort)
+		} else {
+			var f *os.File
+			if f, err = os.Open(v); err == nil {
+				in, out, err = formatFile(f, *sort)
+				f.Close()
+			}
+		}
+		if err != nil {
+			log.Printf("fmt: %s: %v", v, err)
+			status = 1
+			continue
+		}
+
+		switch {
+		case *check:
+			if !bytes.Equal(in, out) {
+				fmt.Println(v)
+				status = 1
+			}
+		case *write && v != "-":
+			if bytes.Equal(in, out) {
+				continue
+			}
+			if err := ioutil.WriteFile(v, out, 0644); err != nil {
+				log.Printf("fmt: %v", err)
+				status = 1
+			}
+		default:
+			os.Stdout.Write(out)
+		}
+	}
+	return status
+}
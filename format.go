@@ -12,6 +12,15 @@ Symlink    l    *dst *src  mode uid gid
 Regex      r,rr *src *dst  uid  gid
 Recursive  R,Rr *src *dst  uid  gid
 Directory  d    *dst  mode uid  gid
+Archive    A    *src  regexp dst uid gid
+Include    include *src
+
+If      if $name | exists path | eq a b
+Else    else
+For     for  *name in items...
+List    list *name items...
+Def     def  *name params...
+End     end
 
 Mode    mm    *idx *regexp  mode uid gid
 Rename  mr    *idx *regexp *dst
@@ -1,3 +1,5 @@
 module github.com/tlahdekorpi/archivegen
 
 go 1.12
+
+require golang.org/x/sys v0.7.0
@@ -0,0 +1,66 @@
+// Package progress reports the progress of building an archive through
+// a function carried by a context.
+package progress
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// Stages of a build.
+const (
+	Resolve = "resolve" // entries added to the configuration.
+	Write   = "write"   // entries written to the archive.
+)
+
+// Event is the state of a stage when an entry is done.
+type Event struct {
+	Stage   string `json:"stage"`
+	Entries int    `json:"entries"`        // entries so far in this stage.
+	Bytes   int64  `json:"bytes"`          // file contents written so far.
+	File    string `json:"file,omitempty"` // current entry.
+}
+
+// Func receives events, it may be called concurrently.
+type Func func(Event)
+
+type key struct{}
+
+// WithFunc returns a context reporting events to fn.
+func WithFunc(ctx context.Context, fn Func) context.Context {
+	return context.WithValue(ctx, key{}, fn)
+}
+
+// Report sends e to the function of ctx, if any.
+func Report(ctx context.Context, e Event) {
+	if ctx == nil {
+		return
+	}
+	if fn, ok := ctx.Value(key{}).(Func); ok && fn != nil {
+		fn(e)
+	}
+}
+
+// Enabled reports whether ctx has a function receiving events.
+func Enabled(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	fn, ok := ctx.Value(key{}).(Func)
+	return ok && fn != nil
+}
+
+// JSON returns a Func writing events to w as JSON lines.
+func JSON(w io.Writer) Func {
+	var (
+		mu  sync.Mutex
+		enc = json.NewEncoder(w)
+	)
+	return func(e Event) {
+		mu.Lock()
+		enc.Encode(e)
+		mu.Unlock()
+	}
+}
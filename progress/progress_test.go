@@ -0,0 +1,52 @@
+package progress
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	Report(nil, Event{})
+	Report(context.Background(), Event{})
+	if Enabled(nil) || Enabled(context.Background()) {
+		t.Error("enabled without a func")
+	}
+	if Enabled(WithFunc(context.Background(), nil)) {
+		t.Error("enabled with a nil func")
+	}
+
+	var have []Event
+	ctx := WithFunc(context.Background(), func(e Event) {
+		have = append(have, e)
+	})
+	if !Enabled(ctx) {
+		t.Error("not enabled")
+	}
+	want := []Event{
+		{Stage: Resolve, Entries: 1, File: "a"},
+		{Stage: Write, Entries: 1, Bytes: 2, File: "a"},
+	}
+	for _, v := range want {
+		Report(ctx, v)
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("have %+v, want %+v", have, want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	b := new(bytes.Buffer)
+	fn := JSON(b)
+	fn(Event{Stage: Resolve, Entries: 1, File: "a"})
+	fn(Event{Stage: Write, Entries: 2, Bytes: 3})
+
+	const want = `{"stage":"resolve","entries":1,"bytes":0,"file":"a"}
+{"stage":"write","entries":2,"bytes":3}
+`
+	if b.String() != want {
+		t.Errorf("have:\n%s\nwant:\n%s", b, want)
+	}
+
+}